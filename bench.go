@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joelchen/gothumb/server"
+	"github.com/joelchen/gothumb/sign"
+)
+
+// One image of the corpus, read from disk or named as a source for -url
+type benchImage struct {
+	name string
+	data []byte
+}
+
+// What one format and size measured
+type benchGroup struct {
+	format, size string
+	latencies    []time.Duration
+	errors       int
+	bytes        int64
+	elapsed      time.Duration
+	peakMemory   int64
+}
+
+// Resizes every image of the corpus to each size and format, locally or by
+// requesting them from a running gothumb, and reports throughput, latency
+// percentiles and peak memory for each combination
+func runBench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	sizes := flags.String("sizes", "", "comma-separated sizes to measure instead of all configured ones")
+	formats := flags.String("formats", "", "comma-separated output formats to measure besides each source's own")
+	iterations := flags.Int("iterations", 10, "times to resize each image to each size")
+	parallel := flags.Int("parallel", runtime.GOMAXPROCS(0), "resizes to run at once")
+	endpoint := flags.String("url", "", "request thumbnails from the gothumb at this URL, taking the corpus as sources")
+	clientID := flags.String("client", "", "sign -url requests with the secret of this client")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 || *iterations < 1 || *parallel < 1 {
+		return fmt.Errorf("Usage: gothumb bench [-sizes LIST] [-formats LIST] [-iterations N] [-parallel N] [-url URL [-client ID]] <file or directory>...")
+	}
+
+	names := strings.Split(*sizes, ",")
+
+	if *sizes == "" {
+		names = server.Sizes()
+	}
+
+	outputs := []string{""}
+
+	if *formats != "" {
+		outputs = append(outputs, strings.Split(*formats, ",")...)
+	}
+
+	var images []benchImage
+	var err error
+
+	if *endpoint == "" {
+		if err = server.Setup(); err != nil {
+			return err
+		}
+
+		if images, err = readCorpus(flags.Args()); err != nil {
+			return err
+		}
+	} else {
+		for _, source := range flags.Args() {
+			images = append(images, benchImage{name: source})
+		}
+	}
+
+	var groups []*benchGroup
+
+	for _, format := range outputs {
+		for _, size := range names {
+			var run func(image benchImage) (int64, error)
+
+			if *endpoint == "" {
+				run = benchLocal(size, format)
+			} else if run, err = benchHTTP(*endpoint, *clientID, *parallel, size, format, images); err != nil {
+				return err
+			}
+
+			group := measure(images, *iterations, *parallel, run)
+			group.format, group.size = format, size
+			groups = append(groups, group)
+		}
+	}
+
+	printBench(groups, *endpoint == "")
+	return nil
+}
+
+// Reads the image files given and those in the directories given
+func readCorpus(paths []string) ([]benchImage, error) {
+	var images []benchImage
+
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+				return err
+			}
+
+			data, err := ioutil.ReadFile(path)
+
+			if err != nil {
+				return err
+			}
+
+			images = append(images, benchImage{path, data})
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(images) == 0 {
+		return nil, fmt.Errorf("No images in the corpus")
+	}
+
+	return images, nil
+}
+
+func benchLocal(size, format string) func(image benchImage) (int64, error) {
+	return func(image benchImage) (int64, error) {
+		data, _, err := server.Render(context.Background(), image.data, size, format)
+		return int64(len(data)), err
+	}
+}
+
+// Requests a size of each source, signed ahead of time so signing is not
+// measured, asking for the format through the Accept header
+func benchHTTP(endpoint, clientID string, parallel int, size, format string, images []benchImage) (func(image benchImage) (int64, error), error) {
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: parallel}}
+	urls := map[string]string{}
+
+	for _, image := range images {
+		signed, err := server.SignURL(size, image.name, sign.Options{}, clientID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		urls[image.name] = strings.TrimSuffix(endpoint, "/") + signed
+	}
+
+	return func(image benchImage) (int64, error) {
+		request, err := http.NewRequest("GET", urls[image.name], nil)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if format != "" {
+			request.Header.Set("Accept", "image/"+format)
+		}
+
+		response, err := client.Do(request)
+
+		if err != nil {
+			return 0, err
+		}
+
+		defer response.Body.Close()
+		n, err := io.Copy(ioutil.Discard, response.Body)
+
+		if err == nil && response.StatusCode != http.StatusOK {
+			err = fmt.Errorf("%s: %s", image.name, response.Status)
+		}
+
+		return n, err
+	}, nil
+}
+
+// Runs every image through run the given number of times on parallel
+// goroutines, sampling the process's memory while they work
+func measure(images []benchImage, iterations, parallel int, run func(image benchImage) (int64, error)) *benchGroup {
+	group := &benchGroup{}
+	jobs := make(chan benchImage)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	stopSampling := make(chan struct{})
+	sampled := make(chan int64)
+
+	go func() {
+		peak := residentMemory()
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if memory := residentMemory(); memory > peak {
+					peak = memory
+				}
+			case <-stopSampling:
+				sampled <- peak
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for image := range jobs {
+				began := time.Now()
+				n, err := run(image)
+				took := time.Since(began)
+
+				mu.Lock()
+
+				if err != nil {
+					group.errors++
+				} else {
+					group.latencies = append(group.latencies, took)
+					group.bytes += n
+				}
+
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < iterations; i++ {
+		for _, image := range images {
+			jobs <- image
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+	group.elapsed = time.Since(start)
+	close(stopSampling)
+	group.peakMemory = <-sampled
+	return group
+}
+
+// Returns the resident set size on Linux, which includes memory held by
+// libvips, or the memory the Go runtime obtained elsewhere
+func residentMemory() int64 {
+	if statm, err := ioutil.ReadFile("/proc/self/statm"); err == nil {
+		if fields := strings.Fields(string(statm)); len(fields) > 1 {
+			if pages, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				return pages * int64(os.Getpagesize())
+			}
+		}
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.Sys)
+}
+
+func printBench(groups []*benchGroup, local bool) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "FORMAT\tSIZE\tRESIZES\tERRORS\tPER SEC\tP50\tP95\tP99\tAVG BYTES\tPEAK MEMORY")
+
+	for _, group := range groups {
+		format := group.format
+
+		if format == "" {
+			format = "source"
+		}
+
+		sort.Slice(group.latencies, func(i, j int) bool { return group.latencies[i] < group.latencies[j] })
+		count := len(group.latencies)
+		var average int64
+
+		if count > 0 {
+			average = group.bytes / int64(count)
+		}
+
+		// The memory of the bench process says nothing about the server's
+		memory := "-"
+
+		if local {
+			memory = fmt.Sprintf("%.1fMB", float64(group.peakMemory)/(1<<20))
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%d\t%s\n",
+			format, group.size, count, group.errors,
+			float64(count)/group.elapsed.Seconds(),
+			percentile(group.latencies, 0.5), percentile(group.latencies, 0.95), percentile(group.latencies, 0.99),
+			average, memory)
+	}
+
+	writer.Flush()
+}
+
+// Returns the latency below which the fraction p of sorted latencies fall
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	return latencies[int(float64(len(latencies)-1)*p)].Round(time.Microsecond)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/joelchen/gothumb/queue"
+	"github.com/joelchen/gothumb/server"
+	"github.com/joelchen/gothumb/sign"
+	"github.com/joelchen/gothumb/source"
+	"github.com/joelchen/gothumb/storage"
+	"github.com/joelchen/gothumb/watch"
+	"github.com/spf13/viper"
+)
+
+// Runs a subcommand and returns the process exit code
+func runCommand(name string, args []string) int {
+	var err error
+
+	switch name {
+	case "sign":
+		err = runSign(args)
+	case "verify":
+		err = runVerify(args)
+	case "generate":
+		err = runGenerate(args)
+	case "worker":
+		err = runWorker(args)
+	case "watch":
+		err = runWatch(args)
+	case "bench":
+		err = runBench(args)
+	case "config":
+		err = runConfig(args)
+	default:
+		err = fmt.Errorf("Unknown command: %s\nUsage: gothumb [sign <size> <source> | verify <url> | generate [file] | worker | watch [dir] | bench <corpus> | config print | config validate [file]]", name)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func runSign(args []string) error {
+	flags := flag.NewFlagSet("sign", flag.ContinueOnError)
+	host := flags.String("host", "", "scheme and host to prefix the signed path with")
+	expires := flags.Duration("expires", 0, "how long the signed URL stays valid")
+	clientID := flags.String("client", "", "sign with the secret of this client")
+	download := flags.String("download", "", "serve the image as an attachment with this filename")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("Usage: gothumb sign [-host URL] [-expires DURATION] [-client ID] [-download FILENAME] <size> <source>")
+	}
+
+	options := sign.Options{Download: *download}
+
+	if *expires > 0 {
+		options.Expires = time.Now().Add(*expires)
+	}
+
+	signed, err := server.SignURL(flags.Arg(0), flags.Arg(1), options, *clientID)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(*host + signed)
+	return nil
+}
+
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	signature := flags.String("signature", "", "signature sent in the header instead of the URL")
+	clientID := flags.String("client", "", "client key ID sent in the header instead of the URL")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("Usage: gothumb verify [-signature SIG] [-client ID] <url>")
+	}
+
+	request, err := http.NewRequest("GET", flags.Arg(0), nil)
+
+	if err != nil {
+		return err
+	}
+
+	if *signature != "" {
+		request.Header.Set(viper.GetString("server.signature-header"), *signature)
+	}
+
+	if *clientID != "" {
+		request.Header.Set(viper.GetString("server.client-header"), *clientID)
+	}
+
+	if err = server.Verify(request); err != nil {
+		return err
+	}
+
+	fmt.Println("Signature valid")
+	return nil
+}
+
+func runGenerate(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	sizes := flags.String("sizes", "", "comma-separated sizes to generate instead of all configured ones")
+	prefix := flags.String("prefix", "", "generate for every object under this prefix in the bucket")
+	parallel := flags.Int("parallel", 4, "sources to process at once")
+	force := flags.Bool("force", false, "regenerate sizes that are already cached")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 1 || flags.NArg() == 1 && *prefix != "" {
+		return fmt.Errorf("Usage: gothumb generate [-sizes LIST] [-parallel N] [-force] [-prefix PREFIX | FILE | -]")
+	}
+
+	if err := server.Setup(); err != nil {
+		return err
+	}
+
+	names := strings.Split(*sizes, ",")
+
+	if *sizes == "" {
+		names = server.Sizes()
+	}
+
+	sources := make(chan string)
+	var failed int64
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < *parallel; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for source := range sources {
+				if err := server.Generate(context.Background(), source, names, *force); err != nil {
+					log.Printf("%s: %v", source, err)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+					continue
+				}
+
+				log.Println(source)
+			}
+		}()
+	}
+
+	var err error
+
+	if *prefix != "" {
+		err = listSources(*prefix, sources)
+	} else {
+		err = readSources(flags.Arg(0), sources)
+	}
+
+	close(sources)
+	wg.Wait()
+	server.Wait()
+
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d sources failed", failed)
+	}
+
+	return nil
+}
+
+// Sends the sources listed one per line in a file, or on stdin for "-" or
+// no file
+func readSources(file string, sources chan<- string) error {
+	var input io.Reader = os.Stdin
+
+	if file != "" && file != "-" {
+		f, err := os.Open(file)
+
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+		input = f
+	}
+
+	scanner := bufio.NewScanner(input)
+
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			sources <- line
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Sends the keys under a prefix in the bucket, leaving out cached thumbnails
+func listSources(prefix string, sources chan<- string) error {
+	if storage.Bucket() == "" {
+		return fmt.Errorf("No cache bucket configured")
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(storage.Bucket()),
+		Prefix: aws.String(strings.TrimPrefix(prefix, "/")),
+	}
+
+	return storage.Service().ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, object := range page.Contents {
+			if key := aws.StringValue(object.Key); !strings.HasPrefix(key, "cache/") {
+				sources <- key
+			}
+		}
+
+		return true
+	})
+}
+
+// Generates thumbnails for jobs from queue.url until SIGINT or SIGTERM
+func runWorker(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("Usage: gothumb worker")
+	}
+
+	if err := server.Setup(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err := queue.Consume(ctx, func(ctx context.Context, job queue.Job) error {
+		sizes := job.Sizes
+
+		if len(sizes) == 0 {
+			sizes = server.Sizes()
+		}
+
+		return server.Generate(ctx, job.Source, sizes, false)
+	})
+
+	server.Wait()
+	return err
+}
+
+// Generates thumbnails for files added to or changed in watch.dir, or the
+// directory given, until SIGINT or SIGTERM
+func runWatch(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Usage: gothumb watch [dir]")
+	}
+
+	if len(args) == 1 {
+		viper.Set("watch.dir", args[0])
+	}
+
+	if err := server.Setup(); err != nil {
+		return err
+	}
+
+	if storage.Bucket() == "" {
+		return fmt.Errorf("No cache bucket configured")
+	}
+
+	sizes := viper.GetStringSlice("watch.sizes")
+
+	if len(sizes) == 0 {
+		sizes = server.Sizes()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err := watch.Run(ctx, func(ctx context.Context, file watch.File) error {
+		data, err := ioutil.ReadFile(file.Path)
+
+		if err != nil {
+			return err
+		}
+
+		validators := source.Validators{}
+
+		if info, err := os.Stat(file.Path); err == nil {
+			validators.LastModified = info.ModTime().UTC().Format(http.TimeFormat)
+		}
+
+		return server.GenerateImage(ctx, file.Source, data, validators, sizes)
+	})
+
+	server.Wait()
+	return err
+}
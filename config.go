@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/joelchen/gothumb/server"
+	"github.com/spf13/viper"
+)
+
+// Prints or checks the config without starting the server
+func runConfig(args []string) error {
+	usage := fmt.Errorf("Usage: gothumb config [print | validate [file]]")
+
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch {
+	case args[0] == "print" && len(args) == 1:
+		return printConfig()
+	case args[0] == "validate" && len(args) <= 2:
+		return validateConfig(args[1:])
+	default:
+		return usage
+	}
+}
+
+// Prints every setting as JSON, with secrets redacted
+func printConfig() error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(server.Settings())
+}
+
+// Validates the config gothumb would start with, or the file given instead
+// of the one found, along with the environment and options
+func validateConfig(args []string) error {
+	if len(args) == 1 {
+		viper.SetConfigFile(args[0])
+
+		if err := viper.ReadInConfig(); err != nil {
+			return err
+		}
+	}
+
+	if err := server.Validate(); err != nil {
+		return err
+	}
+
+	file := viper.ConfigFileUsed()
+
+	if file == "" {
+		file = "no config file"
+	}
+
+	fmt.Println("Config valid: " + file)
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Settings each option before the command overrides
+var flagSettings = map[string]string{
+	"port":      "server.port",
+	"socket":    "server.socket",
+	"bucket":    "s3.bucket",
+	"region":    "s3.region",
+	"unsafe":    "server.unsafe",
+	"admin":     "admin.address",
+	"processor": "processor",
+	"log-level": "log.level",
+}
+
+// Parses the options given before the command and returns the rest. Options
+// take precedence over environment variables, which take precedence over
+// the config file.
+func parseFlags(args []string) ([]string, error) {
+	flags := pflag.NewFlagSet("gothumb", pflag.ContinueOnError)
+	flags.SetInterspersed(false)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gothumb [options] [sign | verify | generate | worker | watch | bench | config] [args]")
+		flags.PrintDefaults()
+	}
+
+	config := flags.StringP("config", "c", "", "read settings from this file instead of GOTHUMB_CONFIG or the search path")
+	flags.IntP("port", "p", 0, "port to listen on")
+	flags.String("socket", "", "unix socket to listen on instead of a port")
+	flags.String("bucket", "", "S3 bucket holding originals and cached thumbnails")
+	flags.String("region", "", "region of the S3 bucket")
+	flags.Bool("unsafe", false, "serve thumbnails without checking signatures")
+	flags.String("admin", "", "address of the admin listener")
+	flags.String("processor", "", `"vips" or "go" to pick the image processor`)
+	flags.String("log-level", "", "debug, info, warn or error")
+
+	for name, key := range flagSettings {
+		if err := viper.BindPFlag(key, flags.Lookup(name)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *config != "" {
+		viper.SetConfigFile(*config)
+	}
+
+	return flags.Args(), nil
+}
@@ -0,0 +1,234 @@
+// Package logging writes structured log lines through zap. log.level
+// applies to every subsystem unless log.levels.<subsystem> overrides it, so
+// one part can be made verbose without flooding the logs with the rest.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joelchen/gothumb/internal/requestid"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Level is how important a log line is
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = map[string]Level{
+	"debug":   Debug,
+	"info":    Info,
+	"warn":    Warn,
+	"warning": Warn,
+	"error":   Error,
+}
+
+// ParseLevel returns the level named debug, info, warn or error
+func ParseLevel(name string) (Level, error) {
+	if level, ok := levelNames[strings.ToLower(name)]; ok {
+		return level, nil
+	}
+
+	return Info, fmt.Errorf("Unknown log level: %s", name)
+}
+
+// Logger built by Setup, which every subsystem writes through. Filtering by
+// level is left to the subsystems, so it logs everything it is given.
+var base = struct {
+	sync.RWMutex
+	logger *zap.Logger
+}{logger: zap.New(newCore("console", zapcore.Lock(os.Stderr)))}
+
+// Setup builds the logger from log.format, "console" or "json", and
+// log.sampling: past log.sampling.initial lines with the same level and
+// message in a second, only every log.sampling.thereafter-th is written.
+// Lines go to log.file when it is set and to stderr otherwise, and those
+// written with the standard library's log package go through it too.
+func Setup() error {
+	format := viper.GetString("log.format")
+
+	if format != "console" && format != "json" {
+		return fmt.Errorf("Unknown log.format: %s", format)
+	}
+
+	out := zapcore.Lock(os.Stderr)
+
+	if file := viper.GetString("log.file"); file != "" {
+		writer, err := rotatingFile(file)
+
+		if err != nil {
+			return err
+		}
+
+		out = zapcore.AddSync(writer)
+	}
+
+	core := newCore(format, out)
+
+	if initial := viper.GetInt("log.sampling.initial"); initial > 0 {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, initial, viper.GetInt("log.sampling.thereafter"))
+	}
+
+	logger := zap.New(core)
+
+	base.Lock()
+	base.logger = logger
+	base.Unlock()
+
+	zap.RedirectStdLog(logger)
+	return nil
+}
+
+func newCore(format string, out zapcore.WriteSyncer) zapcore.Core {
+	config := zap.NewProductionEncoderConfig()
+	config.EncodeTime = zapcore.ISO8601TimeEncoder
+	encoder := zapcore.NewJSONEncoder(config)
+
+	if format == "console" {
+		config.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(config)
+	}
+
+	return zapcore.NewCore(encoder, out, zapcore.DebugLevel)
+}
+
+type fieldsKey struct{}
+
+// With returns a context whose log lines carry the fields, given as
+// alternating keys and values, along with those it already carried
+func With(ctx context.Context, keysAndValues ...interface{}) context.Context {
+	fields := Fields(ctx)
+	return context.WithValue(ctx, fieldsKey{}, append(fields[:len(fields):len(fields)], keysAndValues...))
+}
+
+// Fields returns the fields added to the context with With
+func Fields(ctx context.Context) []interface{} {
+	fields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	return fields
+}
+
+// Logger writes the lines of one subsystem
+type Logger struct {
+	subsystem string
+	fields    []interface{}
+}
+
+// New returns the logger of a subsystem
+func New(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem}
+}
+
+// Ctx returns a logger whose lines carry the request ID and fields of the
+// context
+func (l *Logger) Ctx(ctx context.Context) *Logger {
+	fields := l.fields[:len(l.fields):len(l.fields)]
+
+	if id := requestid.From(ctx); id != "" {
+		fields = append(fields, "request_id", id)
+	}
+
+	return &Logger{subsystem: l.subsystem, fields: append(fields, Fields(ctx)...)}
+}
+
+// Level returns the least important level the subsystem logs, read from
+// the config each time so reloading it takes effect. Unknown names log at
+// info.
+func (l *Logger) Level() Level {
+	name := viper.GetStringMapString("log.levels")[l.subsystem]
+
+	if name == "" {
+		name = viper.GetString("log.level")
+	}
+
+	level, _ := ParseLevel(name)
+	return level
+}
+
+// Enabled reports whether lines at the level are logged, for skipping work
+// that only feeds them
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// Debug logs a message with fields given as alternating keys and values.
+// Keeping the message constant and the details in fields lets sampling
+// group lines.
+func (l *Logger) Debug(message string, keysAndValues ...interface{}) {
+	l.write(Debug, message, keysAndValues)
+}
+
+func (l *Logger) Info(message string, keysAndValues ...interface{}) {
+	l.write(Info, message, keysAndValues)
+}
+
+func (l *Logger) Warn(message string, keysAndValues ...interface{}) {
+	l.write(Warn, message, keysAndValues)
+}
+
+func (l *Logger) Error(message string, keysAndValues ...interface{}) {
+	l.write(Error, message, keysAndValues)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.write(Debug, fmt.Sprintf(format, args...), nil)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.write(Info, fmt.Sprintf(format, args...), nil)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.write(Warn, fmt.Sprintf(format, args...), nil)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.write(Error, fmt.Sprintf(format, args...), nil)
+}
+
+var zapLevels = map[Level]zapcore.Level{
+	Debug: zapcore.DebugLevel,
+	Info:  zapcore.InfoLevel,
+	Warn:  zapcore.WarnLevel,
+	Error: zapcore.ErrorLevel,
+}
+
+func (l *Logger) write(level Level, message string, keysAndValues []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	base.RLock()
+	logger := base.logger
+	base.RUnlock()
+
+	sugar := logger.Named(l.subsystem).Sugar()
+	sugar.Logw(zapLevels[level], message, append(l.fields[:len(l.fields):len(l.fields)], keysAndValues...)...)
+}
+
+// Std returns a standard library logger writing through the subsystem at
+// the level, for packages that take one such as net/http
+func (l *Logger) Std(level Level) *log.Logger {
+	return log.New(writerFunc(func(line []byte) (int, error) {
+		l.write(level, strings.TrimSuffix(string(line), "\n"), nil)
+		return len(line), nil
+	}), "", 0)
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(line []byte) (int, error) {
+	return f(line)
+}
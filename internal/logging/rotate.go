@@ -0,0 +1,51 @@
+package logging
+
+import (
+	"math"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Returns a writer appending to the file, which is rotated once it grows
+// past log.rotation.max-size and, with log.rotation.every set, at each
+// multiple of it, such as midnight UTC for "24h". Rotated files are named
+// after the time they were rotated, compressed with log.rotation.compress,
+// and removed past log.rotation.max-backups files or log.rotation.max-age,
+// which is rounded up to whole days.
+func rotatingFile(file string) (*lumberjack.Logger, error) {
+	// Opened up front so a file that cannot be written stops gothumb
+	// starting rather than losing every line
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f.Close()
+
+	writer := &lumberjack.Logger{
+		Filename:   file,
+		MaxSize:    int(viper.GetSizeInBytes("log.rotation.max-size") >> 20),
+		MaxBackups: viper.GetInt("log.rotation.max-backups"),
+		MaxAge:     int(math.Ceil(viper.GetDuration("log.rotation.max-age").Hours() / 24)),
+		Compress:   viper.GetBool("log.rotation.compress"),
+		LocalTime:  true,
+	}
+
+	if every := viper.GetDuration("log.rotation.every"); every > 0 {
+		go rotateEvery(writer, every)
+	}
+
+	return writer, nil
+}
+
+func rotateEvery(writer *lumberjack.Logger, every time.Duration) {
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(every).Add(every).Sub(now))
+		writer.Rotate()
+	}
+}
@@ -0,0 +1,100 @@
+// Package reporting sends errors worth a look, such as panics, images that
+// fail to process and storage failures, to Sentry and any registered
+// reporters along with the request they happened in
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/joelchen/gothumb/internal/requestid"
+	"github.com/joelchen/gothumb/internal/secrets"
+	"github.com/spf13/viper"
+)
+
+// Reporter receives an error with tags saying what kind it is ("panic",
+// "process" or "storage") and describing the request, such as its
+// request_id, source and size
+type Reporter func(ctx context.Context, err error, tags map[string]string)
+
+var reporters = struct {
+	sync.RWMutex
+	list []Reporter
+}{}
+
+// Register adds a reporter errors are sent to
+func Register(r Reporter) {
+	reporters.Lock()
+	reporters.list = append(reporters.list, r)
+	reporters.Unlock()
+}
+
+// Setup starts the Sentry client when sentry.dsn is set, tagging events
+// with sentry.environment and the release
+func Setup(release string) error {
+	dsn := secrets.Get("sentry.dsn")
+
+	if dsn == "" {
+		return nil
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:              dsn,
+		Environment:      viper.GetString("sentry.environment"),
+		Release:          release,
+		SampleRate:       viper.GetFloat64("sentry.sample-rate"),
+		AttachStacktrace: true,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	Register(sendToSentry)
+	return nil
+}
+
+func sendToSentry(ctx context.Context, err error, tags map[string]string) {
+	hub := sentry.CurrentHub().Clone()
+
+	hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetTags(tags)
+		hub.CaptureException(err)
+	})
+}
+
+// Report sends an error of a kind to every reporter
+func Report(ctx context.Context, err error, kind string) {
+	reporters.RLock()
+	defer reporters.RUnlock()
+
+	if len(reporters.list) == 0 {
+		return
+	}
+
+	tags := map[string]string{"kind": kind}
+
+	if id := requestid.From(ctx); id != "" {
+		tags["request_id"] = id
+	}
+
+	fields := logging.Fields(ctx)
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		tags[fmt.Sprint(fields[i])] = fmt.Sprint(fields[i+1])
+	}
+
+	for _, r := range reporters.list {
+		r(ctx, err, tags)
+	}
+}
+
+// Flush waits up to the timeout for events still being sent, for calling
+// before exiting
+func Flush(timeout time.Duration) {
+	sentry.Flush(timeout)
+}
@@ -0,0 +1,35 @@
+// Package requestid carries the ID of the request being served through
+// contexts, so work done for it can be traced in logs and on other services
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+type key struct{}
+
+// New returns a random request ID
+func New() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// With returns a context carrying the request ID
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// From returns the request ID carried by the context, if any
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
+
+// Detach returns a context carrying the request ID but not the cancellation
+// of the request, for work that outlives the response
+func Detach(ctx context.Context) context.Context {
+	return With(context.Background(), From(ctx))
+}
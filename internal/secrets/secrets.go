@@ -0,0 +1,228 @@
+// Package secrets resolves secret settings such as the signing key and S3
+// credentials from the environment, the config file or a secret backend
+package secrets
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/spf13/viper"
+)
+
+// Filtered by log.levels.secrets
+var logger = logging.New("secrets")
+
+var secrets = struct {
+	sync.RWMutex
+	values   map[string]string
+	onChange []func()
+}{values: map[string]string{}}
+
+// Environment variables that take precedence over the config file for
+// secrets, so deployments never have to write them to disk
+var secretEnv = map[string][]string{
+	"server.key":           {"GOTHUMB_SERVER_KEY"},
+	"server.salt":          {"GOTHUMB_SERVER_SALT"},
+	"s3.access-key-id":     {"GOTHUMB_S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"},
+	"s3.secret-access-key": {"GOTHUMB_S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"},
+	"sentry.dsn":           {"GOTHUMB_SENTRY_DSN", "SENTRY_DSN"},
+	"admin.token":          {"GOTHUMB_ADMIN_TOKEN"},
+	"vault.address":        {"VAULT_ADDR"},
+	"vault.token":          {"VAULT_TOKEN"},
+	"webhooks.secret":      {"GOTHUMB_WEBHOOKS_SECRET"},
+}
+
+// Settings under the secrets section naming where each config key is stored
+var secretSettings = map[string]string{
+	"server.key":           "server-key",
+	"server.salt":          "server-salt",
+	"s3.access-key-id":     "s3-access-key-id",
+	"s3.secret-access-key": "s3-secret-access-key",
+	"webhooks.secret":      "webhooks-secret",
+	"locks.redis":          "locks-redis",
+	"sentry.dsn":           "sentry-dsn",
+	"admin.token":          "admin-token",
+}
+
+// BindEnv binds the environment variables read for secrets
+func BindEnv() {
+	for key, names := range secretEnv {
+		viper.BindEnv(append([]string{key}, names...)...)
+	}
+}
+
+// Get returns the value of a secret config key, preferring one loaded from
+// a secret backend over the environment and config file
+func Get(key string) string {
+	return GetFrom(viper.GetViper(), key)
+}
+
+// GetFrom returns the value of a secret config key in a config, such as a
+// reloaded one not yet in use, still preferring a loaded secret
+func GetFrom(v *viper.Viper, key string) string {
+	secrets.RLock()
+	value, ok := secrets.values[key]
+	secrets.RUnlock()
+
+	if ok {
+		return value
+	}
+
+	return v.GetString(key)
+}
+
+// Stores loaded secrets, returning whether any of them changed
+func setSecrets(values map[string]string) bool {
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	changed := false
+
+	for key, value := range values {
+		if current, ok := secrets.values[key]; !ok || current != value {
+			changed = true
+		}
+
+		secrets.values[key] = value
+	}
+
+	return changed
+}
+
+// Returns a function reading a named secret from the configured backend
+func secretFetcher() (func(name string) (string, error), error) {
+	switch provider := viper.GetString("secrets.provider"); provider {
+	case "secretsmanager", "ssm":
+		return awsSecretFetcher(provider)
+	case "vault":
+		return vaultSecretFetcher()
+	default:
+		return nil, fmt.Errorf("Unknown secrets provider: %s", provider)
+	}
+}
+
+// Reads secrets from AWS Secrets Manager or SSM Parameter Store
+func awsSecretFetcher(provider string) (func(name string) (string, error), error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(viper.GetString("secrets.region")),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if provider == "ssm" {
+		svc := ssm.New(sess)
+
+		return func(name string) (string, error) {
+			output, err := svc.GetParameter(&ssm.GetParameterInput{
+				Name:           aws.String(name),
+				WithDecryption: aws.Bool(true),
+			})
+
+			if err != nil {
+				return "", err
+			}
+
+			return aws.StringValue(output.Parameter.Value), nil
+		}, nil
+	}
+
+	svc := secretsmanager.New(sess)
+
+	return func(name string) (string, error) {
+		output, err := svc.GetSecretValue(&secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(name),
+		})
+
+		if err != nil {
+			return "", err
+		}
+
+		return aws.StringValue(output.SecretString), nil
+	}, nil
+}
+
+// Fetches the secrets named under the secrets section
+func fetchSecrets(fetch func(name string) (string, error)) (map[string]string, error) {
+	values := map[string]string{}
+
+	for key, setting := range secretSettings {
+		name := viper.GetString("secrets." + setting)
+
+		if name == "" {
+			continue
+		}
+
+		value, err := fetch(name)
+
+		if err != nil {
+			return nil, fmt.Errorf("Fetching secret for %s: %v", key, err)
+		}
+
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+// OnChange registers a function called whenever refreshed secrets differ
+// from the ones loaded before
+func OnChange(hook func()) {
+	secrets.Lock()
+	secrets.onChange = append(secrets.onChange, hook)
+	secrets.Unlock()
+}
+
+// Setup loads secrets from the configured backend and keeps refreshing them
+// every secrets.refresh so rotated values are picked up without a redeploy
+func Setup() error {
+	if viper.GetString("secrets.provider") == "" {
+		return nil
+	}
+
+	fetch, err := secretFetcher()
+
+	if err != nil {
+		return err
+	}
+
+	values, err := fetchSecrets(fetch)
+
+	if err != nil {
+		return err
+	}
+
+	setSecrets(values)
+
+	if interval := viper.GetDuration("secrets.refresh"); interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				values, err := fetchSecrets(fetch)
+
+				if err != nil {
+					logger.Errorf("%v", err)
+					continue
+				}
+
+				if setSecrets(values) {
+					secrets.RLock()
+					hooks := secrets.onChange
+					secrets.RUnlock()
+
+					for _, hook := range hooks {
+						hook()
+					}
+				}
+			}
+		}()
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Minimal client for the Vault HTTP API, authenticating with a static token
+// or a Kubernetes service account and renewing its token as it ages
+type vaultClient struct {
+	mu      sync.Mutex
+	address string
+	token   string
+	expires time.Time
+}
+
+type vaultResponse struct {
+	Data json.RawMessage `json:"data"`
+	Auth *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func vaultSecretFetcher() (func(name string) (string, error), error) {
+	v := &vaultClient{address: strings.TrimSuffix(viper.GetString("vault.address"), "/")}
+
+	if err := v.login(); err != nil {
+		return nil, err
+	}
+
+	return v.read, nil
+}
+
+func (v *vaultClient) do(method, path, token string, body interface{}) (*vaultResponse, error) {
+	var payload bytes.Buffer
+
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+
+	request, err := http.NewRequest(method, v.address+"/v1/"+path, &payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token != "" {
+		request.Header.Set("X-Vault-Token", token)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+	result := &vaultResponse{}
+
+	if err = json.NewDecoder(response.Body).Decode(result); err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("Vault returned %d for %s: %s", response.StatusCode, path, strings.Join(result.Errors, ", "))
+	}
+
+	return result, nil
+}
+
+func (v *vaultClient) login() error {
+	if viper.GetString("vault.auth") != "kubernetes" {
+		v.token = Get("vault.token")
+
+		if err := v.renew(); err != nil {
+			v.expires = time.Time{}
+		}
+
+		return nil
+	}
+
+	jwt, err := ioutil.ReadFile(viper.GetString("vault.jwt-path"))
+
+	if err != nil {
+		return err
+	}
+
+	result, err := v.do("POST", "auth/"+viper.GetString("vault.mount")+"/login", "", map[string]string{
+		"role": viper.GetString("vault.role"),
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if result.Auth == nil {
+		return fmt.Errorf("Vault login returned no token")
+	}
+
+	v.token = result.Auth.ClientToken
+	v.expires = time.Now().Add(time.Duration(result.Auth.LeaseDuration) * time.Second)
+	return nil
+}
+
+// Extends the token lease; tokens without a lease are left alone
+func (v *vaultClient) renew() error {
+	result, err := v.do("POST", "auth/token/renew-self", v.token, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if result.Auth == nil || !result.Auth.Renewable {
+		v.expires = time.Time{}
+		return nil
+	}
+
+	v.expires = time.Now().Add(time.Duration(result.Auth.LeaseDuration) * time.Second)
+	return nil
+}
+
+// Renews the token when it expires within vault.renew-before, logging in
+// again when it can no longer be renewed
+func (v *vaultClient) refreshToken() {
+	if v.expires.IsZero() || time.Until(v.expires) > viper.GetDuration("vault.renew-before") {
+		return
+	}
+
+	if err := v.renew(); err == nil {
+		return
+	}
+
+	if err := v.login(); err != nil {
+		logger.Errorf("%v", err)
+	}
+}
+
+// Reads a secret named "path#field", field defaulting to "value". Both
+// KV version 1 and version 2 response layouts are understood.
+func (v *vaultClient) read(name string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.refreshToken()
+
+	path, field := name, "value"
+
+	if i := strings.LastIndex(name, "#"); i >= 0 {
+		path, field = name[:i], name[i+1:]
+	}
+
+	result, err := v.do("GET", path, v.token, nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	var data map[string]interface{}
+
+	if err = json.Unmarshal(result.Data, &data); err != nil {
+		return "", err
+	}
+
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+
+	value, ok := data[field].(string)
+
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no field %s", path, field)
+	}
+
+	return value, nil
+}
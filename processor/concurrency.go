@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+var (
+	resizeLimit semaphore
+	sizeLimits  = map[string]semaphore{}
+	// ErrBusy is returned when no worker or resize slot became available
+	ErrBusy = fmt.Errorf("Too many concurrent resizes")
+)
+
+// Counting semaphore; a nil semaphore never blocks
+type semaphore chan struct{}
+
+func (s semaphore) acquire(ctx context.Context, timeout <-chan time.Time) error {
+	if s == nil {
+		return nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return nil
+	default:
+	}
+
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-timeout:
+		return ErrBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+func setupConcurrencyLimits() {
+	if n := viper.GetInt("concurrency.max"); n > 0 {
+		resizeLimit = make(semaphore, n)
+	}
+
+	for size, value := range viper.GetStringMap("concurrency.sizes") {
+		if n := cast.ToInt(value); n > 0 {
+			sizeLimits[size] = make(semaphore, n)
+		}
+	}
+}
+
+// Acquire waits up to concurrency.max-wait for a resize slot for the size,
+// returning a function that frees it, ErrBusy if none became available or
+// the context's error when it ends first
+func Acquire(ctx context.Context, size string) (func(), error) {
+	timer := time.NewTimer(viper.GetDuration("concurrency.max-wait"))
+	defer timer.Stop()
+
+	sizeLimit := sizeLimits[size]
+
+	if err := sizeLimit.acquire(ctx, timer.C); err != nil {
+		return nil, err
+	}
+
+	if err := resizeLimit.acquire(ctx, timer.C); err != nil {
+		sizeLimit.release()
+		return nil, err
+	}
+
+	return func() {
+		resizeLimit.release()
+		sizeLimit.release()
+	}, nil
+}
@@ -0,0 +1,169 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"image"
+	_ "image/gif" // Registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"runtime"
+	"sort"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Registers the WebP decoder
+)
+
+// Resizes with the standard library and golang.org/x/image, for machines
+// and containers without libvips. It reads JPEG, PNG, GIF and WebP, writes
+// only JPEG and PNG, and always crops around the centre.
+type goProcessor struct{}
+
+func (p *goProcessor) Formats() []string {
+	return []string{"jpeg", "png"}
+}
+
+func (p *goProcessor) Version() string {
+	return "pure Go " + runtime.Version()
+}
+
+func (p *goProcessor) Process(data []byte, options Options) ([]byte, string, error) {
+	output := p.ProcessAll(context.Background(), data, []Options{options})[0]
+	return output.Data, output.ContentType, output.Err
+}
+
+// Decodes the image once and resizes it to the largest size first. Each
+// uncropped thumbnail is then the source for smaller ones, as long as it
+// still has enough pixels to scale down from.
+func (p *goProcessor) ProcessAll(ctx context.Context, data []byte, options []Options) []Output {
+	outputs := make([]Output, len(options))
+	start := time.Now()
+	src, format, err := image.Decode(bytes.NewReader(data))
+	decode := time.Since(start)
+
+	if err != nil {
+		for i := range outputs {
+			outputs[i].Err = err
+		}
+
+		return outputs
+	}
+
+	bounds := src.Bounds()
+	widths := make([]int, len(options))
+	heights := make([]int, len(options))
+	order := make([]int, len(options))
+
+	for i, o := range options {
+		widths[i], heights[i] = fitSize(bounds.Dx(), bounds.Dy(), o)
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return widths[order[a]]*heights[order[a]] > widths[order[b]]*heights[order[b]]
+	})
+
+	base := src
+
+	for _, i := range order {
+		if outputs[i].Err = ctx.Err(); outputs[i].Err != nil {
+			continue
+		}
+
+		start := time.Now()
+		o, width, height := options[i], widths[i], heights[i]
+		cropped := o.Crop && width == o.Width && height == o.Height
+		from, region := base, base.Bounds()
+
+		if cropped {
+			region = centreCrop(region, width, height)
+		}
+
+		if region.Dx() < width || region.Dy() < height {
+			from, region = src, bounds
+
+			if cropped {
+				region = centreCrop(region, width, height)
+			}
+		}
+
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), from, region, draw.Src, nil)
+
+		if !cropped {
+			base = dst
+		}
+
+		outputs[i].Data, outputs[i].ContentType, outputs[i].Err = encodeGo(dst, format, o)
+		outputs[i].Decode, outputs[i].Resize = decode, time.Since(start)
+	}
+
+	return outputs
+}
+
+// Encodes a thumbnail as PNG when asked or when the source was one, and as
+// JPEG otherwise
+func encodeGo(dst image.Image, format string, options Options) ([]byte, string, error) {
+	var out bytes.Buffer
+
+	if options.Format == "png" || options.Format == "" && format == "png" {
+		err := png.Encode(&out, dst)
+		return out.Bytes(), "image/png", err
+	}
+
+	err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: qualityOr(options.Quality, jpeg.DefaultQuality)})
+	return out.Bytes(), "image/jpeg", err
+}
+
+// Returns the output dimensions: the requested box when cropping, otherwise
+// the largest size keeping the aspect ratio that fits in it. A zero width or
+// height leaves that side unconstrained. Images are not enlarged unless
+// options.Enlarge is set.
+func fitSize(width, height int, options Options) (int, int) {
+	if options.Crop && options.Width > 0 && options.Height > 0 {
+		if options.Enlarge || width >= options.Width && height >= options.Height {
+			return options.Width, options.Height
+		}
+	}
+
+	scale := 0.0
+
+	if options.Width > 0 {
+		scale = float64(options.Width) / float64(width)
+	}
+
+	if h := float64(options.Height) / float64(height); options.Height > 0 && (scale == 0 || h < scale) {
+		scale = h
+	}
+
+	if scale == 0 || scale > 1 && !options.Enlarge {
+		return width, height
+	}
+
+	return atLeastOne(float64(width) * scale), atLeastOne(float64(height) * scale)
+}
+
+func atLeastOne(size float64) int {
+	if size < 1 {
+		return 1
+	}
+
+	return int(size + 0.5)
+}
+
+// Returns the centred region of bounds with the aspect ratio of width by
+// height
+func centreCrop(bounds image.Rectangle, width, height int) image.Rectangle {
+	w, h := bounds.Dx(), bounds.Dy()
+
+	if w*height > h*width {
+		cropped := h * width / height
+		x := bounds.Min.X + (w-cropped)/2
+		return image.Rect(x, bounds.Min.Y, x+cropped, bounds.Max.Y)
+	}
+
+	cropped := w * height / width
+	y := bounds.Min.Y + (h-cropped)/2
+	return image.Rect(bounds.Min.X, y, bounds.Max.X, y+cropped)
+}
@@ -0,0 +1,8 @@
+//go:build novips
+
+package processor
+
+// Built with -tags novips to run without libvips
+func newVipsProcessor() (Processor, error) {
+	return nil, ErrNoVips
+}
@@ -0,0 +1,62 @@
+package processor
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Operation transforms a processed thumbnail, returning the new bytes and
+// their content type
+type Operation func(data []byte, contentType string) ([]byte, string, error)
+
+var operations = struct {
+	sync.RWMutex
+	byName map[string]Operation
+}{byName: map[string]Operation{}}
+
+// RegisterOperation makes an operation available to the operations setting
+// under a name. It must be called before Setup.
+func RegisterOperation(name string, op Operation) {
+	operations.Lock()
+	operations.byName[name] = op
+	operations.Unlock()
+}
+
+// CheckOperations rejects names, as given in the operations setting, that
+// were never registered
+func CheckOperations(names []string) error {
+	operations.RLock()
+	defer operations.RUnlock()
+
+	for _, name := range names {
+		if _, ok := operations.byName[name]; !ok {
+			return fmt.Errorf("Unknown operation: %s", name)
+		}
+	}
+
+	return nil
+}
+
+// Apply runs the operations named by the operations setting on a thumbnail,
+// in order
+func Apply(data []byte, contentType string) ([]byte, string, error) {
+	for _, name := range viper.GetStringSlice("operations") {
+		operations.RLock()
+		op, ok := operations.byName[name]
+		operations.RUnlock()
+
+		if !ok {
+			return nil, "", fmt.Errorf("Unknown operation: %s", name)
+		}
+
+		var err error
+
+		if data, contentType, err = op(data, contentType); err != nil {
+			return nil, "", fmt.Errorf("Operation %s: %v", name, err)
+		}
+	}
+
+	return data, contentType, nil
+}
@@ -0,0 +1,154 @@
+// Package processor resizes images on a bounded pool of workers, with
+// libvips or a pure-Go pipeline
+package processor
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"fmt"
+	"image"
+	"image/png"
+	"time"
+
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/spf13/viper"
+)
+
+// Filtered by log.levels.processor
+var logger = logging.New("processor")
+
+// Processor turns source images into thumbnails. Everything above it deals
+// in bytes and options only, so the imaging library can be swapped without
+// touching the handlers.
+type Processor interface {
+	Process(image []byte, options Options) (data []byte, contentType string, err error)
+	// Output formats the processor can encode, by name
+	Formats() []string
+	Version() string
+}
+
+// MultiProcessor is implemented by processors that can derive several
+// thumbnails from one decode of the image
+type MultiProcessor interface {
+	// Returns one output per options, in the same order. Thumbnails not
+	// started when the context ends fail with its error.
+	ProcessAll(ctx context.Context, image []byte, options []Options) []Output
+}
+
+// Output is one thumbnail of a ProcessAll call, or why it failed
+type Output struct {
+	Data        []byte
+	ContentType string
+	Err         error
+	// Time spent decoding the image, the same for every output of a call
+	// and zero when the processor decodes as part of resizing
+	Decode time.Duration
+	// Time spent resizing and encoding this thumbnail
+	Resize time.Duration
+}
+
+// Options describe how to process one thumbnail
+type Options struct {
+	Width   int
+	Height  int
+	Crop    bool
+	Gravity string
+	Enlarge bool
+	Quality int
+	// Output format, or empty for the source's own
+	Format string
+}
+
+// Default is the processor picked by Setup
+var Default Processor
+
+// ErrNoVips is returned for libvips when gothumb was built without it
+var ErrNoVips = fmt.Errorf("Built without libvips")
+
+// Stats counts image processing work, published with the Go memory
+// statistics at /debug/vars on the admin listener
+var Stats = expvar.NewMap("vips")
+
+// Setup picks the processor named by the processor setting: "vips", "go"
+// for the pure-Go pipeline, or by default libvips when gothumb was built
+// with it and the pure-Go pipeline otherwise. It then checks the configured
+// operations, starts the workers and applies the concurrency limits.
+func Setup() error {
+	if err := setupProcessor(); err != nil {
+		return err
+	}
+
+	if err := CheckOperations(viper.GetStringSlice("operations")); err != nil {
+		return err
+	}
+
+	setupConcurrencyLimits()
+	setupWorkers()
+	return nil
+}
+
+func setupProcessor() error {
+	switch name := viper.GetString("processor"); name {
+	case "vips":
+		p, err := newVipsProcessor()
+
+		if err != nil {
+			return err
+		}
+
+		Default = p
+	case "go":
+		Default = &goProcessor{}
+	case "":
+		p, err := newVipsProcessor()
+
+		if err == ErrNoVips {
+			logger.Infof("Built without libvips, using the pure-Go processor")
+			Default = &goProcessor{}
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		Default = p
+	default:
+		return fmt.Errorf("Unknown processor: %s", name)
+	}
+
+	return nil
+}
+
+// Shutdown releases the processor's resources once no more images will be
+// processed
+func Shutdown() {
+	if closer, ok := Default.(interface{ Shutdown() }); ok {
+		closer.Shutdown()
+	}
+}
+
+func qualityOr(quality, fallback int) int {
+	if quality > 0 {
+		return quality
+	}
+
+	return fallback
+}
+
+// Check resizes a tiny image on the worker pool, so a pool that stays
+// saturated takes the instance out of rotation
+func Check() error {
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, _, err := Resize(ctx, buf.Bytes(), Options{Width: 1, Height: 1})
+	return err
+}
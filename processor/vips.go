@@ -0,0 +1,275 @@
+//go:build !novips
+
+package processor
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/davidbyttow/govips/v2/vips"
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/spf13/viper"
+)
+
+// Processes images with libvips through govips
+type vipsProcessor struct{}
+
+var vipsFormats = map[string]vips.ImageType{
+	"jpeg": vips.ImageTypeJPEG,
+	"png":  vips.ImageTypePNG,
+	"webp": vips.ImageTypeWEBP,
+	"avif": vips.ImageTypeAVIF,
+}
+
+var vipsGravities = map[string]vips.Interesting{
+	"":          vips.InterestingCentre,
+	"centre":    vips.InterestingCentre,
+	"center":    vips.InterestingCentre,
+	"smart":     vips.InterestingAttention,
+	"attention": vips.InterestingAttention,
+	"entropy":   vips.InterestingEntropy,
+}
+
+// Most verbose libvips messages passed on at each level of
+// log.levels.processor
+var vipsLogLevels = map[logging.Level]vips.LogLevel{
+	logging.Debug: vips.LogLevelDebug,
+	logging.Info:  vips.LogLevelInfo,
+	logging.Warn:  vips.LogLevelWarning,
+	logging.Error: vips.LogLevelError,
+}
+
+// Logs a libvips message at the matching level
+func logVips(domain string, level vips.LogLevel, message string) {
+	switch {
+	case level <= vips.LogLevelCritical:
+		logger.Error(message, "domain", domain)
+	case level == vips.LogLevelWarning:
+		logger.Warn(message, "domain", domain)
+	case level == vips.LogLevelDebug:
+		logger.Debug(message, "domain", domain)
+	default:
+		logger.Info(message, "domain", domain)
+	}
+}
+
+// Starts libvips with vips.concurrency threads per operation, leaving the
+// number of operations to the worker pool. Unset, it follows GOMAXPROCS,
+// which respects the container's CPU limit where libvips would use every
+// core of the host. The operation cache is sized by vips.cache.
+func newVipsProcessor() (Processor, error) {
+	if _, ok := vipsGravities[viper.GetString("vips.gravity")]; !ok {
+		return nil, fmt.Errorf("Unknown vips.gravity: %s", viper.GetString("vips.gravity"))
+	}
+
+	vips.LoggingSettings(logVips, vipsLogLevels[logger.Level()])
+
+	concurrency := viper.GetInt("vips.concurrency")
+
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	err := vips.Startup(&vips.Config{
+		ConcurrencyLevel: concurrency,
+		MaxCacheSize:     viper.GetInt("vips.cache.operations"),
+		MaxCacheMem:      int(viper.GetSizeInBytes("vips.cache.memory")),
+		MaxCacheFiles:    viper.GetInt("vips.cache.files"),
+		ReportLeaks:      viper.GetBool("vips.leak-check"),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &vipsProcessor{}, nil
+}
+
+// Stops libvips, which reports leaks with vips.leak-check set
+func (p *vipsProcessor) Shutdown() {
+	vips.Shutdown()
+}
+
+func (p *vipsProcessor) Formats() []string {
+	return []string{"jpeg", "png", "webp", "avif"}
+}
+
+func (p *vipsProcessor) Version() string {
+	return "libvips " + vips.Version
+}
+
+func (p *vipsProcessor) Process(image []byte, options Options) ([]byte, string, error) {
+	crop, size := vipsThumbnailMode(options)
+	img, err := vips.NewThumbnailWithSizeFromBuffer(image, options.Width, options.Height, crop, size)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer img.Close()
+	return vipsExport(img, options)
+}
+
+// Thumbnails the largest size first straight from the buffer, so libvips
+// can shrink on load, then cuts each smaller size, cropped or not, from the
+// largest uncropped thumbnail made so far that still has enough pixels.
+// Decoding is part of resizing, so outputs report no decode time.
+func (p *vipsProcessor) ProcessAll(ctx context.Context, image []byte, options []Options) []Output {
+	outputs := make([]Output, len(options))
+	// Only the header is read here, libvips decoding pixels once they are used
+	header, err := vips.NewImageFromBuffer(image)
+
+	if err != nil {
+		for i := range outputs {
+			outputs[i].Err = err
+		}
+
+		return outputs
+	}
+
+	width, height := header.Width(), header.Height()
+
+	// Thumbnails are turned upright, so sizes are worked out the same way
+	if header.Orientation() >= 5 {
+		width, height = height, width
+	}
+
+	header.Close()
+
+	widths := make([]int, len(options))
+	heights := make([]int, len(options))
+	order := make([]int, len(options))
+
+	for i, o := range options {
+		widths[i], heights[i] = fitSize(width, height, o)
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return widths[order[a]]*heights[order[a]] > widths[order[b]]*heights[order[b]]
+	})
+
+	var base *vips.ImageRef
+
+	defer func() {
+		if base != nil {
+			base.Close()
+		}
+	}()
+
+	for _, i := range order {
+		if outputs[i].Err = ctx.Err(); outputs[i].Err != nil {
+			continue
+		}
+
+		start := time.Now()
+		o := options[i]
+		from := base
+
+		if from != nil && (from.Width() < widths[i] || from.Height() < heights[i]) {
+			from = nil
+		}
+
+		img, err := vipsThumbnail(image, from, o)
+
+		if err != nil {
+			outputs[i].Err = err
+			continue
+		}
+
+		outputs[i].Data, outputs[i].ContentType, outputs[i].Err = vipsExport(img, o)
+		outputs[i].Resize = time.Since(start)
+
+		if o.Crop || outputs[i].Err != nil {
+			img.Close()
+			continue
+		}
+
+		if base != nil {
+			base.Close()
+		}
+
+		base = img
+	}
+
+	return outputs
+}
+
+// Thumbnails a copy of an earlier thumbnail, or the image in the buffer
+// when there is none to start from
+func vipsThumbnail(image []byte, from *vips.ImageRef, options Options) (*vips.ImageRef, error) {
+	crop, size := vipsThumbnailMode(options)
+
+	if from == nil {
+		return vips.NewThumbnailWithSizeFromBuffer(image, options.Width, options.Height, crop, size)
+	}
+
+	img, err := from.Copy()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = img.ThumbnailWithSize(options.Width, options.Height, crop, size); err != nil {
+		img.Close()
+		return nil, err
+	}
+
+	return img, nil
+}
+
+func vipsThumbnailMode(options Options) (vips.Interesting, vips.Size) {
+	crop := vips.InterestingNone
+
+	if options.Crop {
+		crop = vipsGravities[options.Gravity]
+	}
+
+	if options.Enlarge {
+		return crop, vips.SizeBoth
+	}
+
+	return crop, vips.SizeDown
+}
+
+// Encodes a thumbnail in the requested format, or in its source's format
+func vipsExport(img *vips.ImageRef, options Options) ([]byte, string, error) {
+	format, ok := vipsFormats[options.Format]
+
+	if !ok {
+		format = img.Format()
+	}
+
+	var buf []byte
+	var err error
+
+	switch format {
+	case vips.ImageTypePNG:
+		params := vips.NewPngExportParams()
+		params.StripMetadata = true
+		buf, _, err = img.ExportPng(params)
+		return buf, "image/png", err
+	case vips.ImageTypeWEBP:
+		params := vips.NewWebpExportParams()
+		params.StripMetadata = true
+		params.Quality = qualityOr(options.Quality, params.Quality)
+		buf, _, err = img.ExportWebp(params)
+		return buf, "image/webp", err
+	case vips.ImageTypeAVIF:
+		params := vips.NewAvifExportParams()
+		params.StripMetadata = true
+		params.Quality = qualityOr(options.Quality, params.Quality)
+		buf, _, err = img.ExportAvif(params)
+		return buf, "image/avif", err
+	default:
+		// Formats without an encoder here, such as GIF and TIFF, become JPEG
+		params := vips.NewJpegExportParams()
+		params.StripMetadata = true
+		params.Quality = qualityOr(options.Quality, params.Quality)
+		buf, _, err = img.ExportJpeg(params)
+		return buf, "image/jpeg", err
+	}
+}
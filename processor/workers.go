@@ -0,0 +1,161 @@
+package processor
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Resizes waiting for a worker
+var resizeQueue chan *resizeJob
+
+type resizeJob struct {
+	ctx     context.Context
+	image   []byte
+	options []Options
+	done    chan resizeResult
+	// Whether a worker took the job or its caller stopped waiting for one
+	state int32
+}
+
+const (
+	jobQueued int32 = iota
+	jobStarted
+	jobAbandoned
+)
+
+type resizeResult struct {
+	outputs []Output
+	err     error
+}
+
+// Starts workers.count resize workers, one per CPU by default, each on its
+// own OS thread so processor calls never outnumber them, with room for
+// workers.queue resizes to wait
+func setupWorkers() {
+	count := viper.GetInt("workers.count")
+
+	if count <= 0 {
+		count = runtime.NumCPU()
+	}
+
+	resizeQueue = make(chan *resizeJob, viper.GetInt("workers.queue"))
+
+	for i := 0; i < count; i++ {
+		go resizeWorker()
+	}
+}
+
+func resizeWorker() {
+	runtime.LockOSThread()
+
+	for job := range resizeQueue {
+		Stats.Add("queued", -1)
+
+		// Skip resizes whose requests gave up while queued
+		if !atomic.CompareAndSwapInt32(&job.state, jobQueued, jobStarted) {
+			continue
+		}
+
+		if err := job.ctx.Err(); err != nil {
+			job.done <- resizeResult{err: err}
+			continue
+		}
+
+		Stats.Add("in_flight", 1)
+		start := time.Now()
+		outputs := processAll(job.ctx, job.image, job.options)
+		Stats.Add("in_flight", -1)
+		logger.Ctx(job.ctx).Debug("resized", "thumbnails", len(outputs), "bytes", len(job.image), "duration", time.Since(start))
+		job.done <- resizeResult{outputs: outputs}
+	}
+}
+
+// Decodes the image once for all the options when the processor supports
+// it, and once per thumbnail otherwise, giving up on the thumbnails left
+// once the context ends
+func processAll(ctx context.Context, image []byte, options []Options) []Output {
+	if multi, ok := Default.(MultiProcessor); ok && len(options) > 1 {
+		Stats.Add("shared_decodes", int64(len(options)-1))
+		return multi.ProcessAll(ctx, image, options)
+	}
+
+	outputs := make([]Output, len(options))
+
+	for i, o := range options {
+		if outputs[i].Err = ctx.Err(); outputs[i].Err != nil {
+			continue
+		}
+
+		start := time.Now()
+		outputs[i].Data, outputs[i].ContentType, outputs[i].Err = Default.Process(image, o)
+		outputs[i].Resize = time.Since(start)
+	}
+
+	return outputs
+}
+
+// Resize processes an image on the worker pool, returning ErrBusy when the
+// queue is full or no worker took it within deadlines.queue, or the
+// context's error when it ends first
+func Resize(ctx context.Context, image []byte, options Options) ([]byte, string, error) {
+	outputs, err := ResizeAll(ctx, image, []Options{options})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return outputs[0].Data, outputs[0].ContentType, outputs[0].Err
+}
+
+// ResizeAll processes several thumbnails of an image as one job on the
+// worker pool, which decodes the image once for all of them where the
+// processor allows. Outputs are in the order of the options. The job takes
+// at most deadlines.resize, queueing included, though a thumbnail already
+// being resized runs to the end.
+func ResizeAll(ctx context.Context, image []byte, options []Options) ([]Output, error) {
+	if timeout := viper.GetDuration("deadlines.resize"); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	job := &resizeJob{ctx: ctx, image: image, options: options, done: make(chan resizeResult, 1)}
+
+	Stats.Add("queued", 1)
+
+	select {
+	case resizeQueue <- job:
+	default:
+		Stats.Add("queued", -1)
+		return nil, ErrBusy
+	}
+
+	var queueTimeout <-chan time.Time
+
+	if wait := viper.GetDuration("deadlines.queue"); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		queueTimeout = timer.C
+	}
+
+	for {
+		select {
+		case result := <-job.done:
+			return result.outputs, result.err
+		case <-ctx.Done():
+			atomic.CompareAndSwapInt32(&job.state, jobQueued, jobAbandoned)
+			return nil, ctx.Err()
+		case <-queueTimeout:
+			if atomic.CompareAndSwapInt32(&job.state, jobQueued, jobAbandoned) {
+				return nil, ErrBusy
+			}
+
+			// A worker has it already
+			queueTimeout = nil
+		}
+	}
+}
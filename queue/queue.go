@@ -0,0 +1,237 @@
+// Package queue consumes thumbnail jobs from an SQS queue, so pipelines can
+// enqueue work for the cache instead of requesting every size over HTTP
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/joelchen/gothumb/storage"
+	"github.com/spf13/viper"
+)
+
+// Filtered by log.levels.queue
+var logger = logging.New("queue")
+
+// Job asks for sizes of a source to be generated, all configured sizes when
+// none are given. Jobs received together run highest priority first.
+type Job struct {
+	Source   string   `json:"source"`
+	Sizes    []string `json:"sizes,omitempty"`
+	Priority int      `json:"priority,omitempty"`
+}
+
+// The jobs of one message, which is deleted once all of them succeed
+type delivery struct {
+	jobs     []Job
+	priority int
+	receipt  *string
+}
+
+// Consume receives jobs from queue.url until the context is done, running
+// up to queue.parallel at once. Messages are deleted once their jobs
+// succeed; failed ones become visible again after the queue's visibility
+// timeout, and SQS moves them to a dead-letter queue if one is configured.
+func Consume(ctx context.Context, handle func(context.Context, Job) error) error {
+	queueURL := viper.GetString("queue.url")
+
+	if queueURL == "" {
+		return fmt.Errorf("No queue.url configured")
+	}
+
+	config := storage.Config()
+
+	if region := viper.GetString("queue.region"); region != "" {
+		config.Region = aws.String(region)
+	}
+
+	sess, err := session.NewSession(config)
+
+	if err != nil {
+		return err
+	}
+
+	svc := sqs.New(sess)
+	deliveries := make(chan delivery)
+	var wg sync.WaitGroup
+
+	for i := 0; i < viper.GetInt("queue.parallel"); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for d := range deliveries {
+				if !handleAll(ctx, d.jobs, handle) {
+					continue
+				}
+
+				_, err := svc.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+					QueueUrl:      aws.String(queueURL),
+					ReceiptHandle: d.receipt,
+				})
+
+				if err != nil {
+					logger.Errorf("%v", err)
+				}
+			}
+		}()
+	}
+
+	defer wg.Wait()
+	defer close(deliveries)
+
+	for ctx.Err() == nil {
+		output, err := svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		})
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Keep polling through throttling and network errors
+		if err != nil {
+			logger.Errorf("%v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		var batch []delivery
+
+		for _, message := range output.Messages {
+			jobs, err := parseMessage(aws.StringValue(message.Body))
+
+			if err != nil {
+				logger.Errorf("Message %s: %v", aws.StringValue(message.MessageId), err)
+				continue
+			}
+
+			d := delivery{jobs: jobs, receipt: message.ReceiptHandle}
+
+			for _, job := range jobs {
+				if job.Priority > d.priority {
+					d.priority = job.Priority
+				}
+			}
+
+			batch = append(batch, d)
+		}
+
+		sort.SliceStable(batch, func(i, j int) bool { return batch[i].priority > batch[j].priority })
+
+		for _, d := range batch {
+			deliveries <- d
+		}
+	}
+
+	return nil
+}
+
+// Runs the jobs of a message, reporting whether all of them succeeded
+func handleAll(ctx context.Context, jobs []Job, handle func(context.Context, Job) error) bool {
+	ok := true
+
+	for _, job := range jobs {
+		if err := handle(ctx, job); err != nil {
+			logger.Errorf("%s: %v", job.Source, err)
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// Notification from S3, possibly delivered through SNS
+type event struct {
+	// Set when SNS wraps the S3 notification
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+	// s3:TestEvent when notifications are set up
+	Event string `json:"Event"`
+
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// Parses a message holding a job or an S3 event notification. Objects
+// created in the cache bucket become jobs for queue.event-sizes, or every
+// size when unset; their keys under cache/ are thumbnails and are skipped.
+func parseMessage(body string) ([]Job, error) {
+	var e event
+
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		return nil, err
+	}
+
+	if e.Type == "Notification" {
+		return parseMessage(e.Message)
+	}
+
+	if e.Event == "s3:TestEvent" {
+		return nil, nil
+	}
+
+	if e.Records == nil {
+		var job Job
+
+		if err := json.Unmarshal([]byte(body), &job); err != nil {
+			return nil, err
+		}
+
+		if job.Source == "" {
+			return nil, fmt.Errorf("Job without a source")
+		}
+
+		return []Job{job}, nil
+	}
+
+	var jobs []Job
+
+	for _, record := range e.Records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			continue
+		}
+
+		if record.S3.Bucket.Name != storage.Bucket() {
+			logger.Debugf("Skipping event for bucket %s", record.S3.Bucket.Name)
+			continue
+		}
+
+		// Keys in S3 events are form-encoded
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(key, "cache/") {
+			continue
+		}
+
+		jobs = append(jobs, Job{Source: key, Sizes: viper.GetStringSlice("queue.event-sizes")})
+	}
+
+	return jobs, nil
+}
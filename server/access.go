@@ -0,0 +1,196 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Address ranges from the config, replaced as a whole when it is reloaded
+var accessLists = struct {
+	sync.RWMutex
+	allowed, denied, trusted cidrList
+}{}
+
+type cidrList []*net.IPNet
+
+// Parses CIDR ranges, treating bare addresses as single-host ranges
+func parseCIDRs(values []string) (cidrList, error) {
+	var list cidrList
+
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CIDR %q: %v", value, err)
+		}
+
+		list = append(list, network)
+	}
+
+	return list, nil
+}
+
+func (l cidrList) contains(ip net.IP) bool {
+	for _, network := range l {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Whether a connection comes from a trusted proxy. Peers on the unix socket
+// are local and always trusted
+func trustedPeer(remoteAddr string) bool {
+	if viper.GetString("server.socket") != "" {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+
+	if err != nil {
+		host = remoteAddr
+	}
+
+	accessLists.RLock()
+	defer accessLists.RUnlock()
+
+	return accessLists.trusted.contains(net.ParseIP(host))
+}
+
+// Parses the allow, deny and trusted proxy lists, keeping the current ones
+// if any fails
+func setupAccessLists() error {
+	allowed, err := parseCIDRs(viper.GetStringSlice("access.allow"))
+
+	if err != nil {
+		return err
+	}
+
+	denied, err := parseCIDRs(viper.GetStringSlice("access.deny"))
+
+	if err != nil {
+		return err
+	}
+
+	trusted, err := parseCIDRs(viper.GetStringSlice("server.trusted-proxies"))
+
+	if err != nil {
+		return err
+	}
+
+	accessLists.Lock()
+	accessLists.allowed, accessLists.denied, accessLists.trusted = allowed, denied, trusted
+	accessLists.Unlock()
+
+	return nil
+}
+
+func ipAllowed(ip net.IP) bool {
+	accessLists.RLock()
+	defer accessLists.RUnlock()
+
+	if ip == nil || accessLists.denied.contains(ip) {
+		return false
+	}
+
+	return len(accessLists.allowed) == 0 || accessLists.allowed.contains(ip)
+}
+
+func filterIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if !ipAllowed(net.ParseIP(clientIP(request))) {
+			err := fmt.Errorf("Address not allowed")
+			auditFailure(request, 618, err)
+			httpError(writer, request, err, 618)
+			return
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// Returns the address of the client, following the forwarding header
+// through trusted proxies only so callers cannot spoof their address
+func clientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+
+	if err != nil {
+		host = request.RemoteAddr
+	}
+
+	if !trustedPeer(request.RemoteAddr) {
+		return host
+	}
+
+	hops := forwardedFor(request)
+	accessLists.RLock()
+	trusted := accessLists.trusted
+	accessLists.RUnlock()
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		host = hops[i]
+
+		if !trusted.contains(net.ParseIP(host)) {
+			break
+		}
+	}
+
+	return host
+}
+
+// Returns the addresses the request was forwarded for, nearest last, from
+// X-Forwarded-For or from the RFC 7239 Forwarded header when
+// server.forwarded-header is "Forwarded". Only the header the proxies set
+// is read, as the other one passes through from the client untouched.
+func forwardedFor(request *http.Request) []string {
+	var hops []string
+
+	if !strings.EqualFold(viper.GetString("server.forwarded-header"), "Forwarded") {
+		for _, value := range request.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(value, ",") {
+				if hop = strings.TrimSpace(hop); hop != "" {
+					hops = append(hops, hop)
+				}
+			}
+		}
+
+		return hops
+	}
+
+	for _, value := range request.Header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+
+				if len(pair) < 4 || !strings.EqualFold(pair[:4], "for=") {
+					continue
+				}
+
+				hop := strings.Trim(pair[4:], `"`)
+
+				if host, _, err := net.SplitHostPort(hop); err == nil {
+					hop = host
+				}
+
+				hops = append(hops, strings.Trim(hop, "[]"))
+			}
+		}
+	}
+
+	return hops
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/spf13/viper"
+)
+
+type accessKey struct{}
+
+// One line of the access log, filled in as the request is handled
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Size      string    `json:"size,omitempty"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Cache     string    `json:"cache,omitempty"`
+	Duration  float64   `json:"duration_ms"`
+	ClientIP  string    `json:"client_ip"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// Records the status and body size written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Returns the access log entry of the request so handlers can add the size
+// and cache outcome, or a throwaway entry when access logging is off
+func accessInfo(request *http.Request) *accessEntry {
+	if entry, ok := request.Context().Value(accessKey{}).(*accessEntry); ok {
+		return entry
+	}
+
+	return &accessEntry{}
+}
+
+// Access log lines, filtered by log.levels.access
+var accessLogger = logging.New("access")
+
+// Writes one access log line per request, with its details as fields or as
+// plain text depending on log.access-format; "off" disables it
+func logAccess(next http.Handler) http.Handler {
+	format := viper.GetString("log.access-format")
+
+	if format == "off" {
+		return next
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{
+			Time:      start.UTC(),
+			Method:    request.Method,
+			Path:      request.URL.EscapedPath(),
+			ClientIP:  clientIP(request),
+			RequestID: requestID(request.Context()),
+		}
+
+		recorder := &statusRecorder{ResponseWriter: writer}
+		next.ServeHTTP(recorder, request.WithContext(context.WithValue(request.Context(), accessKey{}, entry)))
+
+		entry.Status = recorder.status
+		entry.Bytes = recorder.bytes
+		entry.Duration = float64(time.Since(start).Microseconds()) / 1000
+
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+
+		if format == "json" {
+			accessLogger.Info("request", "method", entry.Method, "path", entry.Path, "size", entry.Size,
+				"status", entry.Status, "bytes", entry.Bytes, "cache", entry.Cache, "duration_ms", entry.Duration,
+				"client_ip", entry.ClientIP, "request_id", entry.RequestID)
+			return
+		}
+
+		accessLogger.Infof("%s %s %s %d %d %s %.1fms", entry.ClientIP, entry.Method, entry.Path,
+			entry.Status, entry.Bytes, entry.Cache, entry.Duration)
+	})
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/joelchen/gothumb/internal/secrets"
+	"github.com/joelchen/gothumb/storage"
+	"github.com/spf13/viper"
+)
+
+func adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handleHealth)
+	mux.HandleFunc("/readyz", handleReady)
+	mux.HandleFunc("/version", handleVersion)
+	mux.HandleFunc("/purge", handlePurge)
+	mux.HandleFunc("/config", handleConfig)
+	mux.HandleFunc("/toggles", handleToggles)
+	mux.HandleFunc("/keys", handleKeys)
+	mux.HandleFunc("/usage", handleUsage)
+	mux.HandleFunc("/budgets", handleBudgets)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	if viper.GetBool("admin.pprof") {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	return mux
+}
+
+// Serves health, metrics, diagnostics, purging and the running config on
+// admin.address, which should be bound to a private interface such as
+// 127.0.0.1:6060
+func serveAdmin() {
+	listenerFailed(http.ListenAndServe(viper.GetString("admin.address"), adminHandler()))
+}
+
+// Checks that the request carries admin.token as a bearer token, answering
+// it otherwise. Routes that change the running server need it.
+func authorizeAdmin(writer http.ResponseWriter, request *http.Request) bool {
+	token := secrets.Get("admin.token")
+
+	if token == "" {
+		http.Error(writer, "No admin.token configured", http.StatusNotFound)
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(bearerToken(request)), []byte(token)) != 1 {
+		http.Error(writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// Deletes the cached thumbnails of a source, for the sizes given as size
+// parameters or for every size when there are none, of the tenant given as
+// tenant or of the top-level config. Requests must carry admin.token as a
+// bearer token:
+//
+//	curl -X POST -H 'Authorization: Bearer ...' 'localhost:6060/purge?source=images/cat.jpg&size=small'
+func handlePurge(writer http.ResponseWriter, request *http.Request) {
+	if !authorizeAdmin(writer, request) {
+		return
+	}
+
+	if request.Method != "POST" {
+		writer.Header().Set("Allow", "POST")
+		http.Error(writer, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if storage.Bucket() == "" {
+		http.Error(writer, "No cache bucket configured", http.StatusNotFound)
+		return
+	}
+
+	source, err := url.Parse(strings.TrimPrefix(request.FormValue("source"), "/"))
+
+	if err != nil || source.Path == "" {
+		http.Error(writer, "Invalid source", http.StatusBadRequest)
+		return
+	}
+
+	var t *tenant
+
+	if name := request.FormValue("tenant"); name != "" {
+		if t, err = lookupTenant(name); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	keys, err := purge(request.Context(), t, source, request.Form["size"])
+
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(map[string]interface{}{"purged": keys})
+}
+
+// Deletes the cached thumbnails of a source for the sizes, or every size
+// when none are given, and returns their keys
+func purge(ctx context.Context, t *tenant, source *url.URL, sizes []string) ([]string, error) {
+	svc := storage.Service()
+	keys, err := cachedPaths(ctx, svc, t, source, sizes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		_, err = svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(storage.Bucket()),
+			Key:    aws.String(key),
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hotResults.evict(keys)
+	return keys, nil
+}
+
+// Returns the cache keys holding thumbnails of the source, including
+// watermarked copies
+func cachedPaths(ctx context.Context, svc *s3.S3, t *tenant, source *url.URL, sizes []string) ([]string, error) {
+	if len(sizes) > 0 {
+		var keys []string
+
+		for _, size := range sizes {
+			thumb := thumbnail{Size: resolveSize(t, size)}
+			keys = append(keys, t.cachePath(source, thumb.variant()))
+			thumb.Watermark = true
+			keys = append(keys, t.cachePath(source, thumb.variant()))
+		}
+
+		return keys, nil
+	}
+
+	// Variants sit in a directory of their own between the source's
+	// directory and its file name
+	dir, file := path.Split(t.cachePath(source, "*"))
+	prefix := strings.TrimSuffix(dir, "*/")
+
+	var keys []string
+
+	err := svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(storage.Bucket()),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, object := range page.Contents {
+			rest := strings.TrimPrefix(aws.StringValue(object.Key), prefix)
+
+			if parts := strings.Split(rest, "/"); len(parts) == 2 && parts[1] == file {
+				keys = append(keys, aws.StringValue(object.Key))
+			}
+		}
+
+		return true
+	})
+
+	return keys, err
+}
+
+// Settings whose values are never shown by the config endpoint
+var redactedWords = []string{"key", "secret", "salt", "password", "token", "dsn"}
+
+// Returns the running config as JSON with secrets redacted
+func handleConfig(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(Settings())
+}
+
+// Settings returns every setting after defaults, the config file, the
+// environment and options are applied, with secrets redacted
+func Settings() map[string]interface{} {
+	return redact(viper.AllSettings())
+}
+
+func redact(settings map[string]interface{}) map[string]interface{} {
+	redacted := map[string]interface{}{}
+
+	for name, value := range settings {
+		if nested, ok := value.(map[string]interface{}); ok {
+			redacted[name] = redact(nested)
+			continue
+		}
+
+		redacted[name] = value
+
+		for _, word := range redactedWords {
+			if strings.Contains(strings.ToLower(name), word) {
+				redacted[name] = "<redacted>"
+				break
+			}
+		}
+	}
+
+	return redacted
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	authFailures = expvar.NewMap("auth_failures")
+	auditQueue   = make(chan []byte, 100)
+	auditClient  = &http.Client{Timeout: 10 * time.Second}
+)
+
+type auditEvent struct {
+	Time      time.Time `json:"time"`
+	Reason    string    `json:"reason"`
+	Error     string    `json:"error"`
+	ClientIP  string    `json:"client_ip"`
+	KeyID     string    `json:"key_id,omitempty"`
+	Path      string    `json:"path"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// Records a rejected request in the log, the auth_failures counters and,
+// when audit.webhook is set, a webhook
+func auditFailure(request *http.Request, code int, err error) {
+	reason := lookupError(err, code).Name
+	authFailures.Add(reason, 1)
+
+	event, _ := json.Marshal(&auditEvent{
+		Time:      time.Now().UTC(),
+		Reason:    reason,
+		Error:     err.Error(),
+		ClientIP:  clientIP(request),
+		KeyID:     requestClientID(request),
+		Path:      request.URL.EscapedPath(),
+		RequestID: requestID(request.Context()),
+	})
+
+	logger.Infof("audit: %s", event)
+
+	if viper.GetString("audit.webhook") != "" {
+		select {
+		case auditQueue <- event:
+		default:
+			logger.Warnf("audit: webhook queue full, dropping event")
+		}
+	}
+}
+
+// Delivers queued audit events to audit.webhook one at a time
+func sendAuditEvents() {
+	for event := range auditQueue {
+		response, err := auditClient.Post(viper.GetString("audit.webhook"), "application/json", bytes.NewReader(event))
+
+		if err != nil {
+			logger.Errorf("audit: %v", err)
+			continue
+		}
+
+		response.Body.Close()
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/joelchen/gothumb/internal/secrets"
+	"github.com/joelchen/gothumb/sign"
+	"github.com/joelchen/gothumb/signing"
+	"github.com/julienschmidt/httprouter"
+	"github.com/spf13/viper"
+)
+
+// Checks that the request carries a valid bearer token or URL signature and
+// that the client it identifies may request the size and source. Returns
+// the client, if any, or an error with its status code.
+func authorizeRequest(request *http.Request, params httprouter.Params, size string) (*client, int, error) {
+	t := requestTenant(request)
+
+	if err := t.allows(params.ByName("source")); err != nil {
+		return nil, 614, err
+	}
+
+	if raw := bearerToken(request); raw != "" && viper.GetBool("jwt.enabled") {
+		c, err := parseToken(raw)
+
+		if err != nil {
+			return nil, 615, err
+		}
+
+		if err = c.allows(size, params.ByName("source")); err != nil {
+			return nil, 614, err
+		}
+
+		return c, 0, nil
+	}
+
+	var c *client
+	keys := t.signingKeys()
+
+	if id := requestClientID(request); id != "" {
+		var err error
+
+		if c, err = lookupClient(id); err != nil {
+			return nil, 613, err
+		}
+
+		if err = c.allows(size, params.ByName("source")); err != nil {
+			return nil, 614, err
+		}
+
+		keys = c.Keys
+	}
+
+	if viper.GetBool("server.unsafe") || viper.GetBool("hotlink.unsigned") && refererMatches(request) {
+		return c, 0, nil
+	}
+
+	signature, sourcePath := signing.RequestSignature(request, params.ByName("signature"))
+
+	if err := signing.Validate(signature, sourcePath, keys); err != nil {
+		return nil, 602, err
+	}
+
+	if err := signing.ValidateExpiry(request.URL.Query().Get("expires")); err != nil {
+		return nil, 612, err
+	}
+
+	return c, 0, nil
+}
+
+// SignURL builds the path and query the server expects for the size and
+// source, signed with the first key of the client or server. In imgproxy
+// mode the size may also be given as processing options.
+func SignURL(size, source string, options sign.Options, clientID string) (string, error) {
+	keys := signing.Keys()
+
+	if clientID != "" {
+		c, err := lookupClient(clientID)
+
+		if err != nil {
+			return "", err
+		}
+
+		keys = c.Keys
+	}
+
+	if len(keys) == 0 {
+		return "", fmt.Errorf("No signing key configured")
+	}
+
+	signer := &sign.Signer{
+		Secret:      keys[0].Secret,
+		Algorithm:   keys[0].Algorithm,
+		Param:       viper.GetString("server.signature-param"),
+		ClientID:    clientID,
+		ClientParam: viper.GetString("server.client-param"),
+	}
+
+	switch {
+	case signing.ImgproxyMode():
+		var err error
+
+		if size, err = imgproxyOptions(size); err != nil {
+			return "", err
+		}
+
+		signer.Mode = sign.Imgproxy
+		signer.Salt = secrets.Get("server.salt")
+	case signing.ThumborMode():
+		signer.Mode = sign.Thumbor
+	case signing.InPath():
+		signer.Mode = sign.Path
+	}
+
+	return signer.Sign(size, source, options)
+}
+
+// Verify checks a thumbnail request the way the server would, without
+// fetching or resizing anything
+func Verify(request *http.Request) error {
+	_, params, _ := newRouter().Lookup("GET", request.URL.Path)
+	t := requestTenant(request)
+	ok := params != nil
+
+	switch {
+	case urlOptionsMode() && ok:
+		var err error
+
+		if params, _, err = urlOptionParams(request, params.ByName("signature")); err != nil {
+			return err
+		}
+
+		ok = params != nil
+	case !urlOptionsMode():
+		params, ok = thumbnailParams(t, request.URL.Path, params)
+	}
+
+	if !ok {
+		return fmt.Errorf("URL does not match a thumbnail route")
+	}
+
+	size := resolveSize(t, params.ByName("size"))
+
+	if _, _, err := parseWidthAndHeight(t, size); err != nil {
+		return err
+	}
+
+	_, _, err := authorizeRequest(request, params, size)
+	return err
+}
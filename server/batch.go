@@ -0,0 +1,225 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Renders several sizes of one source at /batch/<source>?size=small&size=large
+// for callers presenting batch.token or, with jwt.enabled, a token whose
+// client may request every size. The original is fetched once, and the
+// thumbnails come back as multipart/mixed or, when the client accepts
+// application/zip, as a zip archive.
+func withBatch(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if !strings.HasPrefix(request.URL.Path, "/batch/") {
+			next.ServeHTTP(w, request)
+			return
+		}
+
+		request = withUsage(request)
+		served := &statusRecorder{ResponseWriter: w}
+		defer func() { billResponse(request.Context(), served.bytes) }()
+
+		writer := bufferResponse(served)
+		defer writer.commit()
+
+		sourcePath := strings.TrimPrefix(request.URL.Path, "/batch/")
+		t := requestTenant(request)
+
+		if err := t.allows(sourcePath); err != nil {
+			httpError(writer, request, err, 614)
+			return
+		}
+
+		c, err := batchClient(request)
+
+		if err != nil {
+			auditFailure(request, 615, err)
+			httpError(writer, request, err, 615)
+			return
+		}
+
+		sizes := request.URL.Query()["size"]
+
+		if len(sizes) == 0 {
+			sizes = sizeNames(t)
+		}
+
+		for i, size := range sizes {
+			sizes[i] = resolveSize(t, size)
+
+			if c != nil {
+				if err := c.allows(sizes[i], sourcePath); err != nil {
+					httpError(writer, request, err, 614)
+					return
+				}
+			}
+		}
+
+		if code, err := checkQuota(t, c); err != nil {
+			httpError(writer, request, err, code)
+			return
+		}
+
+		billRequest(request, t, c)
+		results, code, err := batchResults(request.Context(), t, sourcePath, sizes)
+
+		if err != nil {
+			httpError(writer, request, err, code)
+			return
+		}
+
+		writer.Header().Set("Cache-Control", "no-store")
+
+		if strings.Contains(request.Header.Get("Accept"), "application/zip") {
+			err = writeZip(writer, sourcePath, results)
+		} else {
+			err = writeMultipart(writer, results)
+		}
+
+		if err != nil {
+			httpError(writer, request, err, 611)
+		}
+	})
+}
+
+func batchClient(request *http.Request) (*client, error) {
+	raw := bearerToken(request)
+
+	if raw == "" {
+		return nil, fmt.Errorf("Missing token")
+	}
+
+	if token := viper.GetString("batch.token"); token != "" && subtle.ConstantTimeCompare([]byte(raw), []byte(token)) == 1 {
+		return nil, nil
+	}
+
+	if !viper.GetBool("jwt.enabled") {
+		return nil, fmt.Errorf("Invalid token")
+	}
+
+	return parseToken(raw)
+}
+
+// Returns the tenant's sizes of a source in order, from the cache bucket
+// where possible, fetching and decoding the original at most once for the
+// rest
+func batchResults(ctx context.Context, t *tenant, sourcePath string, sizes []string) ([]*result, int, error) {
+	sourceURL, err := url.Parse(strings.TrimPrefix(sourcePath, "/"))
+
+	if err != nil {
+		return nil, 603, err
+	}
+
+	results := make([]*result, len(sizes))
+	var missing []int
+	var paths []string
+	var thumbs []thumbnail
+
+	for i, size := range sizes {
+		width, height, err := parseWidthAndHeight(t, size)
+
+		if err != nil {
+			return nil, 601, fmt.Errorf("%s: %v", size, err)
+		}
+
+		thumb := thumbnail{Tenant: t, Source: sourcePath, Size: size, Width: width, Height: height}
+		resultPath := t.cachePath(sourceURL, thumb.variant())
+
+		if result, ok := cachedResult(ctx, t, resultPath, size); ok {
+			results[i] = result
+			continue
+		}
+
+		missing = append(missing, i)
+		paths = append(paths, resultPath)
+		thumbs = append(thumbs, thumb)
+	}
+
+	if len(missing) == 0 {
+		return results, 0, nil
+	}
+
+	if err := checkBudget(); err != nil {
+		return nil, 623, err
+	}
+
+	body, validators, err := fetchOriginal(ctx, t, sourceURL)
+
+	if err != nil {
+		return nil, 604, err
+	}
+
+	rendered, err := renderThumbnails(ctx, body, paths, thumbs, validators)
+
+	if err != nil {
+		return nil, 605, err
+	}
+
+	for j, i := range missing {
+		results[i] = rendered[j]
+	}
+
+	return results, 0, nil
+}
+
+// Writes one part per thumbnail, named after its size
+func writeMultipart(writer http.ResponseWriter, results []*result) error {
+	parts := multipart.NewWriter(writer)
+	writer.Header().Set("Content-Type", "multipart/mixed; boundary="+parts.Boundary())
+
+	for _, result := range results {
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":        {result.ContentType},
+			"Content-Disposition": {fmt.Sprintf("attachment; name=%q", result.Size)},
+			"Etag":                {`"` + result.ETag + `"`},
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if _, err = part.Write(result.Data); err != nil {
+			return err
+		}
+	}
+
+	return parts.Close()
+}
+
+// Writes the thumbnails as <size>/<file> entries of an uncompressed zip, as
+// images gain nothing from deflating
+func writeZip(writer http.ResponseWriter, sourcePath string, results []*result) error {
+	writer.Header().Set("Content-Type", "application/zip")
+	archive := zip.NewWriter(writer)
+	file := path.Base(sourcePath)
+
+	for _, result := range results {
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     result.Size + "/" + file,
+			Method:   zip.Store,
+			Modified: result.LastModified,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if _, err = entry.Write(result.Data); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/joelchen/gothumb/internal/reporting"
+	"github.com/spf13/viper"
+)
+
+var (
+	errBudgetExceeded = fmt.Errorf("Budget exceeded")
+	budgetStats       = expvar.NewMap("budgets")
+	// Periods and what their budgets cap, as set under budgets.<period>
+	budgetPeriods = []string{"daily", "monthly"}
+	budgetMetrics = []string{"source-bytes", "generation-seconds"}
+)
+
+// Spending in the current day and month, in UTC, against budgets.daily and
+// budgets.monthly
+var budgets = struct {
+	sync.Mutex
+	periods map[string]*budgetPeriod
+}{periods: map[string]*budgetPeriod{"daily": {}, "monthly": {}}}
+
+type budgetPeriod struct {
+	// The day or month counted, as 2006-01-02 or 2006-01
+	Start             string  `json:"start"`
+	SourceBytes       int64   `json:"source_bytes"`
+	GenerationSeconds float64 `json:"generation_seconds"`
+	// Budgets already alerted on
+	Exceeded map[string]bool `json:"exceeded,omitempty"`
+}
+
+func (p *budgetPeriod) get(metric string) float64 {
+	if metric == "source-bytes" {
+		return float64(p.SourceBytes)
+	}
+
+	return p.GenerationSeconds
+}
+
+// Sent to webhooks.url when a budget runs out
+type budgetEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Budget string    `json:"budget"`
+	Limit  float64   `json:"limit"`
+	Spent  float64   `json:"spent"`
+	Until  time.Time `json:"until"`
+}
+
+// Loads the spending saved to budgets.file and keeps saving it every
+// budgets.save-interval, so restarts do not start the budgets over
+func setupBudgets() error {
+	file := viper.GetString("budgets.file")
+
+	if file == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		periods := map[string]*budgetPeriod{}
+
+		if err = json.Unmarshal(data, &periods); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+
+		budgets.Lock()
+
+		for _, period := range budgetPeriods {
+			if p, ok := periods[period]; ok && p != nil {
+				budgets.periods[period] = p
+			}
+		}
+
+		budgets.Unlock()
+	}
+
+	if interval := viper.GetDuration("budgets.save-interval"); interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				if err := saveBudgets(); err != nil {
+					logger.Errorf("Saving budgets: %v", err)
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Writes the spending to budgets.file
+func saveBudgets() error {
+	file := viper.GetString("budgets.file")
+
+	if file == "" {
+		return nil
+	}
+
+	budgets.Lock()
+	data, err := json.Marshal(budgets.periods)
+	budgets.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(file, data)
+}
+
+// Returns the start of the day or month now is in, and when it ends
+func budgetWindow(period string, now time.Time) (string, time.Time) {
+	now = now.UTC()
+
+	if period == "daily" {
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		return day.Format("2006-01-02"), day.AddDate(0, 0, 1)
+	}
+
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return month.Format("2006-01"), month.AddDate(0, 1, 0)
+}
+
+// Returns the spending of the period now is in, starting it over once the
+// day or month has passed. budgets must be locked.
+func currentBudget(period string, now time.Time) *budgetPeriod {
+	start, _ := budgetWindow(period, now)
+	p := budgets.periods[period]
+
+	if p.Start != start {
+		p = &budgetPeriod{Start: start}
+		budgets.periods[period] = p
+	}
+
+	return p
+}
+
+// Returns the budget of a period for a metric, or 0 for none. Source bytes
+// may be given as sizes such as "500GB".
+func budgetLimit(period, metric string) float64 {
+	key := "budgets." + period + "." + metric
+
+	if metric == "source-bytes" {
+		return float64(viper.GetSizeInBytes(key))
+	}
+
+	return viper.GetFloat64(key)
+}
+
+// Counts bytes read from sources and seconds spent decoding and resizing
+// against the budgets, alerting on those running out
+func spendBudget(bytes int64, seconds float64) {
+	now := time.Now()
+	var exceeded []*budgetEvent
+
+	budgets.Lock()
+
+	for _, period := range budgetPeriods {
+		p := currentBudget(period, now)
+		p.SourceBytes += bytes
+		p.GenerationSeconds += seconds
+
+		for _, metric := range budgetMetrics {
+			limit := budgetLimit(period, metric)
+
+			if limit <= 0 || p.get(metric) < limit || p.Exceeded[metric] {
+				continue
+			}
+
+			if p.Exceeded == nil {
+				p.Exceeded = map[string]bool{}
+			}
+
+			p.Exceeded[metric] = true
+			_, until := budgetWindow(period, now)
+			exceeded = append(exceeded, &budgetEvent{
+				Time:   now.UTC(),
+				Event:  "budget_exceeded",
+				Budget: period + "." + metric,
+				Limit:  limit,
+				Spent:  p.get(metric),
+				Until:  until,
+			})
+		}
+	}
+
+	budgets.Unlock()
+
+	for _, event := range exceeded {
+		alertBudget(event)
+	}
+}
+
+// Logs, reports and sends to webhooks.url a budget running out
+func alertBudget(event *budgetEvent) {
+	budgetStats.Add("exceeded", 1)
+	logger.Error("budget exceeded, serving cached thumbnails only", "budget", "budgets."+event.Budget, "limit", event.Limit, "spent", event.Spent, "until", event.Until.Format(time.RFC3339))
+	reporting.Report(context.Background(), fmt.Errorf("Budget budgets.%s exceeded", event.Budget), "budget")
+	queueWebhook(event)
+}
+
+// Returns when the budgets of the metrics, by default all of them, allow
+// fetching and generating again, or the zero time if they do now
+func budgetExhausted(metrics ...string) time.Time {
+	if len(metrics) == 0 {
+		metrics = budgetMetrics
+	}
+
+	now := time.Now()
+	var until time.Time
+
+	budgets.Lock()
+	defer budgets.Unlock()
+
+	for _, period := range budgetPeriods {
+		p := currentBudget(period, now)
+
+		for _, metric := range metrics {
+			if limit := budgetLimit(period, metric); limit > 0 && p.get(metric) >= limit {
+				if _, end := budgetWindow(period, now); end.After(until) {
+					until = end
+				}
+			}
+		}
+	}
+
+	return until
+}
+
+// Refuses work that would fetch a source or generate a thumbnail once a
+// budget of the metrics, by default all of them, is spent
+func checkBudget(metrics ...string) error {
+	if budgetExhausted(metrics...).IsZero() {
+		return nil
+	}
+
+	budgetStats.Add("rejected", 1)
+	return errBudgetExceeded
+}
+
+// Returns the seconds until the budgets allow generating again
+func budgetRetryAfter() int {
+	until := budgetExhausted()
+
+	if until.IsZero() {
+		return 1
+	}
+
+	return int(math.Ceil(time.Until(until).Seconds()))
+}
+
+// A period's spending as /budgets shows it
+type budgetStatus struct {
+	Start             string             `json:"start"`
+	Until             time.Time          `json:"until"`
+	SourceBytes       int64              `json:"source_bytes"`
+	GenerationSeconds float64            `json:"generation_seconds"`
+	Limits            map[string]float64 `json:"limits,omitempty"`
+	Exceeded          []string           `json:"exceeded,omitempty"`
+}
+
+// Shows the spending of the current day and month against their budgets.
+// Requests must carry admin.token as a bearer token:
+//
+//	curl -H 'Authorization: Bearer ...' localhost:6060/budgets
+func handleBudgets(writer http.ResponseWriter, request *http.Request) {
+	if !authorizeAdmin(writer, request) {
+		return
+	}
+
+	now := time.Now()
+	statuses := map[string]budgetStatus{}
+
+	budgets.Lock()
+
+	for _, period := range budgetPeriods {
+		p := currentBudget(period, now)
+		_, until := budgetWindow(period, now)
+		status := budgetStatus{Start: p.Start, Until: until, SourceBytes: p.SourceBytes, GenerationSeconds: p.GenerationSeconds}
+
+		for _, metric := range budgetMetrics {
+			limit := budgetLimit(period, metric)
+
+			if limit <= 0 {
+				continue
+			}
+
+			if status.Limits == nil {
+				status.Limits = map[string]float64{}
+			}
+
+			status.Limits[metric] = limit
+
+			if p.get(metric) >= limit {
+				status.Exceeded = append(status.Exceeded, metric)
+			}
+		}
+
+		statuses[period] = status
+	}
+
+	budgets.Unlock()
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(writer).Encode(statuses)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/joelchen/gothumb/signing"
+	"github.com/spf13/viper"
+)
+
+// A named consumer with its own signing secret and optional restrictions
+// on the sizes and sources it may request, configured under clients.<id>
+type client struct {
+	ID      string
+	Keys    []signing.Key
+	Sizes   []string
+	Sources []string
+}
+
+// Returns the client key ID supplied as a header or query parameter
+func requestClientID(request *http.Request) string {
+	if id := request.Header.Get(viper.GetString("server.client-header")); id != "" {
+		return id
+	}
+
+	return request.URL.Query().Get(viper.GetString("server.client-param"))
+}
+
+func lookupClient(id string) (*client, error) {
+	return current().lookupClient(id)
+}
+
+// Client IDs are matched without regard to case, as config keys are, and
+// returned lowercased so each client has the one rate limit bucket
+func (s settings) lookupClient(id string) (*client, error) {
+	id = strings.ToLower(id)
+	prefix := "clients." + id
+
+	if id == "" || strings.Contains(id, ".") || !s.IsSet(prefix) {
+		return nil, fmt.Errorf("Unknown client")
+	}
+
+	algorithm := s.GetString(prefix + ".algorithm")
+
+	if algorithm == "" {
+		algorithm = signing.DefaultAlgorithmFrom(s.Viper)
+	}
+
+	c := &client{
+		ID:      id,
+		Sizes:   s.GetStringSlice(prefix + ".sizes"),
+		Sources: s.GetStringSlice(prefix + ".sources"),
+	}
+
+	secrets := append([]string{s.GetString(prefix + ".secret")}, s.GetStringSlice(prefix+".previous-secrets")...)
+
+	for _, secret := range secrets {
+		if secret != "" {
+			c.Keys = append(c.Keys, signing.Key{Secret: secret, Algorithm: algorithm})
+		}
+	}
+
+	return c, nil
+}
+
+func (c *client) allows(size, source string) error {
+	if len(c.Sizes) > 0 && !containsString(c.Sizes, size) {
+		return fmt.Errorf("Size not allowed for client")
+	}
+
+	if len(c.Sources) == 0 {
+		return nil
+	}
+
+	if !sourceAllowed(source, c.Sources) {
+		return fmt.Errorf("Source not allowed for client")
+	}
+
+	return nil
+}
+
+// Reports whether the source lies under one of the prefixes, matching whole
+// path segments so a/b does not allow a/bc. Sources climbing out with ..
+// never match, as buckets clean allowed/../secret.jpg to secret.jpg.
+func sourceAllowed(source string, prefixes []string) bool {
+	source = strings.TrimPrefix(source, "/")
+
+	for _, segment := range strings.Split(source, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+
+	for _, prefix := range prefixes {
+		prefix = strings.TrimPrefix(prefix, "/")
+
+		if !strings.HasPrefix(source, prefix) {
+			continue
+		}
+
+		if prefix == "" || len(source) == len(prefix) || strings.HasSuffix(prefix, "/") || source[len(prefix)] == '/' {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+var sourceTests = []struct {
+	source  string
+	allowed bool
+}{
+	{"/allowed/cat.jpg", true},
+	{"allowed/cat.jpg", true},
+	{"/allowed/nested/cat.jpg", true},
+	{"/allowed", true},
+	{"/allowedother/cat.jpg", false},
+	{"/other/cat.jpg", false},
+	{"/allowed/../secret.jpg", false},
+	{"/allowed/nested/../../secret.jpg", false},
+	{"/allowed/..", false},
+	{"/allowed/..cat.jpg", true},
+	{"/cdn.example.com/images/cat.jpg", true},
+	{"/cdn.example.com/imagesother/cat.jpg", false},
+	{"/cdn.example.com/images/../private/cat.jpg", false},
+}
+
+var sourcePrefixes = []string{"allowed", "/cdn.example.com/images/"}
+
+func TestClientAllows(t *testing.T) {
+	c := &client{ID: "app", Sources: sourcePrefixes}
+
+	for _, test := range sourceTests {
+		if err := c.allows("small", test.source); (err == nil) != test.allowed {
+			t.Errorf("allows(%q) = %v, want allowed %v", test.source, err, test.allowed)
+		}
+	}
+
+	if err := (&client{ID: "app"}).allows("small", "/any/../secret.jpg"); err != nil {
+		t.Errorf("client without sources: %v", err)
+	}
+
+	if err := (&client{ID: "app", Sizes: []string{"small"}}).allows("large", "/allowed/cat.jpg"); err == nil {
+		t.Error("allowed a size missing from the client's sizes")
+	}
+}
+
+func TestTenantAllows(t *testing.T) {
+	testConfig(t, map[string]interface{}{
+		"tenants.shop.hosts":   []string{"shop.example.com"},
+		"tenants.shop.sources": sourcePrefixes,
+		"tenants.open.hosts":   []string{"open.example.com"},
+	})
+	defer viper.Reset()
+
+	shop, err := lookupTenant("shop")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range sourceTests {
+		if err := shop.allows(test.source); (err == nil) != test.allowed {
+			t.Errorf("allows(%q) = %v, want allowed %v", test.source, err, test.allowed)
+		}
+	}
+
+	open, err := lookupTenant("open")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var none *tenant
+
+	for _, other := range []*tenant{open, none} {
+		if err := other.allows("/other/cat.jpg"); err != nil {
+			t.Errorf("tenant %v without sources: %v", other, err)
+		}
+	}
+}
+
+func TestLookupClientNormalizesID(t *testing.T) {
+	testConfig(t, map[string]interface{}{"clients.app.secret": "0123456789abcdef"})
+	defer viper.Reset()
+
+	for _, id := range []string{"app", "App", "APP"} {
+		c, err := lookupClient(id)
+
+		if err != nil {
+			t.Fatalf("lookupClient(%q): %v", id, err)
+		}
+
+		if c.ID != "app" {
+			t.Errorf("lookupClient(%q).ID = %q, want app", id, c.ID)
+		}
+	}
+}
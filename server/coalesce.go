@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/joelchen/gothumb/processor"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/singleflight"
+)
+
+// Thumbnails being generated, by cache path, with the requests waiting for
+// each
+var generations = struct {
+	sync.Mutex
+	group   singleflight.Group
+	flights map[string]*flight
+}{flights: map[string]*flight{}}
+
+// A generation shared by the requests waiting for it, cancelled once all of
+// them have gone
+type flight struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// The outcome of a generation shared by every request waiting for it
+type generation struct {
+	result *result
+	code   int
+}
+
+// Runs produce once for all concurrent requests of the same thumbnail and
+// hands each of them the result, or the error with its internal code. The
+// work is detached from the request that started it, so clients giving up
+// do not fail the others waiting, and is bounded by server.request-timeout
+// instead. It is cancelled when every client waiting has disconnected, so
+// abandoned thumbnails stop fetching and resizing. With locks.redis set,
+// other instances are coalesced too. Once a budget is spent, nothing new is
+// generated.
+func coalesce(request *http.Request, path, size string, produce func(ctx context.Context) (*result, int, error)) (*result, int, error) {
+	accessInfo(request).Cache = "miss"
+
+	if err := checkBudget(); err != nil {
+		return nil, 623, err
+	}
+
+	f := joinFlight(request.Context(), path)
+	defer leaveFlight(path, f)
+
+	outcomes := generations.group.DoChan(path, func() (interface{}, error) {
+		result, code, err := leased(f.ctx, requestTenant(request), path, size, produce)
+		return &generation{result, code}, err
+	})
+
+	select {
+	case outcome := <-outcomes:
+		if outcome.Shared {
+			processor.Stats.Add("coalesced", 1)
+		}
+
+		generation := outcome.Val.(*generation)
+		return generation.result, generation.code, outcome.Err
+	case <-request.Context().Done():
+		return nil, 605, request.Context().Err()
+	}
+}
+
+func joinFlight(ctx context.Context, path string) *flight {
+	generations.Lock()
+	defer generations.Unlock()
+
+	f, ok := generations.flights[path]
+
+	if !ok {
+		f = &flight{}
+
+		if timeout := viper.GetDuration("server.request-timeout"); timeout > 0 {
+			f.ctx, f.cancel = context.WithTimeout(detachContext(ctx), timeout)
+		} else {
+			f.ctx, f.cancel = context.WithCancel(detachContext(ctx))
+		}
+
+		generations.flights[path] = f
+	}
+
+	f.waiters++
+	return f
+}
+
+// Cancels the generation once its last request has its result or has gone.
+// A generation abandoned half way is forgotten, so the next request for the
+// thumbnail starts over rather than sharing its cancellation.
+func leaveFlight(path string, f *flight) {
+	generations.Lock()
+	defer generations.Unlock()
+
+	if f.waiters--; f.waiters > 0 {
+		return
+	}
+
+	f.cancel()
+	delete(generations.flights, path)
+	generations.group.Forget(path)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Reports whether the client's copy is current. If-Modified-Since is only
+// considered when the request has no If-None-Match, as RFC 7232 requires.
+func notModified(request *http.Request, result *result) bool {
+	if request.Header.Get("If-None-Match") != "" {
+		return etagMatches(request, result.ETag)
+	}
+
+	since, err := http.ParseTime(request.Header.Get("If-Modified-Since"))
+
+	if err != nil || result.LastModified.IsZero() {
+		return false
+	}
+
+	return !result.LastModified.Truncate(time.Second).After(since)
+}
+
+// Reports whether If-None-Match lists the ETag, using the weak comparison
+// RFC 7232 prescribes for GET
+func etagMatches(request *http.Request, etag string) bool {
+	header := request.Header.Get("If-None-Match")
+
+	if header == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.Trim(strings.TrimPrefix(candidate, "W/"), `"`) == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Replies 304, dropping the headers that only describe a body
+func writeNotModified(writer http.ResponseWriter) {
+	writer.Header().Del("Content-Type")
+	writer.Header().Del("Content-Length")
+	writer.WriteHeader(http.StatusNotModified)
+}
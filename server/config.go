@@ -0,0 +1,148 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joelchen/gothumb/internal/secrets"
+	"github.com/spf13/viper"
+)
+
+// SetDefaults points viper at the config file, sets the defaults of every
+// setting and reads settings from GOTHUMB_ environment variables
+func SetDefaults() {
+	setConfigPaths()
+	viper.SetDefault("server.signature-header", "Signature")
+	viper.SetDefault("server.signature-param", "sig")
+	viper.SetDefault("server.client-header", "Key-Id")
+	viper.SetDefault("server.client-param", "key")
+	viper.SetDefault("jwt.algorithm", "HS256")
+	viper.SetDefault("rate-limit.burst", 10)
+	viper.SetDefault("concurrency.retry-after", 1)
+	viper.SetDefault("workers.queue", 64)
+	viper.SetDefault("vips.cache.operations", 0)
+	viper.SetDefault("vips.cache.memory", "0")
+	viper.SetDefault("vips.cache.files", 0)
+	viper.SetDefault("queue.parallel", 4)
+	viper.SetDefault("webhooks.retries", 3)
+	viper.SetDefault("uploads.queue", 100)
+	viper.SetDefault("uploads.workers", 4)
+	viper.SetDefault("uploads.retries", 5)
+	viper.SetDefault("uploads.backoff", "500ms")
+	viper.SetDefault("uploads.timeout", "30s")
+	viper.SetDefault("memory.request-limit", "64MB")
+	viper.SetDefault("hot-cache.size", "64MB")
+	viper.SetDefault("hot-cache.ttl", "5m")
+	viper.SetDefault("locks.prefix", "gothumb:lock:")
+	viper.SetDefault("locks.ttl", "30s")
+	viper.SetDefault("locks.poll", "250ms")
+	viper.SetDefault("originals.enabled", false)
+	viper.SetDefault("dynamic-sizes.min", 1)
+	viper.SetDefault("dynamic-sizes.step", 1)
+	viper.SetDefault("watch.parallel", 4)
+	viper.SetDefault("watch.settle", "2s")
+	viper.SetDefault("prefetch.queue", 100)
+	viper.SetDefault("prefetch.rate", 5)
+	viper.SetDefault("source.transport.dial-timeout", "30s")
+	viper.SetDefault("source.transport.keep-alive", "30s")
+	viper.SetDefault("source.transport.keep-alives", true)
+	viper.SetDefault("source.transport.max-idle-conns", 100)
+	viper.SetDefault("source.transport.max-idle-conns-per-host", 32)
+	viper.SetDefault("source.transport.idle-conn-timeout", "90s")
+	viper.SetDefault("source.transport.tls-handshake-timeout", "10s")
+	viper.SetDefault("source.transport.http2", true)
+	viper.SetDefault("source.breaker.failures", 5)
+	viper.SetDefault("source.breaker.cooldown", "30s")
+	viper.SetDefault("server.tls.autocert.cache-dir", "certs")
+	viper.SetDefault("hotlink.action", "deny")
+	viper.SetDefault("hotlink.allow-empty", true)
+	viper.SetDefault("cache-control.surrogate-headers", []string{"Surrogate-Key", "Cache-Tag"})
+	viper.SetDefault("log.access-format", "text")
+	viper.SetDefault("log.level", "info")
+	viper.SetDefault("log.format", "console")
+	viper.SetDefault("log.sampling.thereafter", 100)
+	viper.SetDefault("log.rotation.max-size", "100MB")
+	viper.SetDefault("log.rotation.max-backups", 10)
+	viper.SetDefault("sentry.sample-rate", 1.0)
+	viper.SetDefault("statsd.prefix", "gothumb.")
+	viper.SetDefault("statsd.interval", "10s")
+	viper.SetDefault("statsd.format", "statsd")
+	viper.SetDefault("server.shutdown-timeout", "30s")
+	viper.SetDefault("server.response-buffer", "8MB")
+	viper.SetDefault("server.drain-delay", "0s")
+	viper.SetDefault("server.http2", true)
+	viper.SetDefault("server.health-routes", true)
+	viper.SetDefault("server.middleware", defaultMiddleware)
+	viper.SetDefault("server.key-grace", "24h")
+	viper.SetDefault("usage.quota-status", 429)
+	viper.SetDefault("usage.save-interval", "1m")
+	viper.SetDefault("budgets.save-interval", "1m")
+	viper.SetDefault("reload.exclude", []string{"server.port", "server.socket", "server.reuse-port", "server.tls", "admin.address"})
+	viper.SetDefault("server.socket-mode", "0660")
+	viper.SetDefault("server.forwarded-header", "X-Forwarded-For")
+	viper.SetDefault("fallback.max-age", 60)
+	viper.SetDefault("redirect.expires", "15m")
+	viper.SetDefault("redirect.max-age", 60)
+	viper.SetDefault("server.read-header-timeout", "10s")
+	viper.SetDefault("server.read-timeout", "30s")
+	viper.SetDefault("server.write-timeout", "60s")
+	viper.SetDefault("server.idle-timeout", "120s")
+	viper.SetDefault("server.request-timeout", "55s")
+	viper.SetDefault("cors.allowed-methods", []string{"GET", "HEAD"})
+	viper.SetDefault("cors.exposed-headers", []string{"ETag", "Content-Length"})
+	viper.SetDefault("vault.mount", "kubernetes")
+	viper.SetDefault("vault.jwt-path", "/var/run/secrets/kubernetes.io/serviceaccount/token")
+	viper.SetDefault("vault.renew-before", "1m")
+
+	// Every setting can also come from GOTHUMB_ followed by its key in
+	// upper case, with dots and dashes as underscores
+	viper.SetEnvPrefix("gothumb")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.AutomaticEnv()
+	secrets.BindEnv()
+}
+
+// Reads the file named by GOTHUMB_CONFIG, or else the first config.* found
+// in the working directory, the user's XDG config directory, the XDG system
+// config directories and /etc/gothumb, in that order
+func setConfigPaths() {
+	if file := os.Getenv("GOTHUMB_CONFIG"); file != "" {
+		viper.SetConfigFile(file)
+		return
+	}
+
+	viper.SetConfigName("config")
+	viper.AddConfigPath(".")
+
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		viper.AddConfigPath(filepath.Join(dir, "gothumb"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		viper.AddConfigPath(filepath.Join(home, ".config", "gothumb"))
+	}
+
+	dirs := os.Getenv("XDG_CONFIG_DIRS")
+
+	if dirs == "" {
+		dirs = "/etc/xdg"
+	}
+
+	for _, dir := range filepath.SplitList(dirs) {
+		if dir != "" {
+			viper.AddConfigPath(filepath.Join(dir, "gothumb"))
+		}
+	}
+
+	viper.AddConfigPath("/etc/gothumb")
+}
+
+// A config settings are read from: the one in use, or a reloaded file that
+// is validated before it takes its place
+type settings struct {
+	*viper.Viper
+}
+
+// Returns the config in use
+func current() settings {
+	return settings{viper.GetViper()}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+func corsOriginAllowed(origin string) bool {
+	for _, pattern := range viper.GetStringSlice("cors.allowed-origins") {
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Adds CORS headers for origins in cors.allowed-origins and answers
+// preflight requests without reaching the router
+func handleCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if len(viper.GetStringSlice("cors.allowed-origins")) == 0 {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		origin := request.Header.Get("Origin")
+		header := writer.Header()
+		header.Add("Vary", "Origin")
+
+		if origin == "" || !corsOriginAllowed(origin) {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		header.Set("Access-Control-Allow-Origin", origin)
+
+		if exposed := viper.GetStringSlice("cors.exposed-headers"); len(exposed) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+		}
+
+		if request.Method != "OPTIONS" || request.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", strings.Join(viper.GetStringSlice("cors.allowed-methods"), ", "))
+
+		if allowed := viper.GetStringSlice("cors.allowed-headers"); len(allowed) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(allowed, ", "))
+		}
+
+		if maxAge := viper.GetInt("cors.max-age"); maxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(maxAge))
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Bounds a stage by deadlines.<stage> when it is set. Calling the returned
+// function ends the deadline without cancelling the context, so a cached
+// thumbnail found in time can be streamed for as long as the request lasts.
+func stageDeadline(ctx context.Context, stage string) (context.Context, func()) {
+	timeout := viper.GetDuration("deadlines." + stage)
+
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	// The context otherwise ends with its parent, which every request's
+	// does
+	ctx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(timeout, cancel)
+	return ctx, func() { timer.Stop() }
+}
+
+// Bounds a stage whose context is done with once it returns, such as
+// storing a thumbnail, by deadlines.<stage> when it is set
+func stageTimeout(ctx context.Context, stage string) (context.Context, context.CancelFunc) {
+	if timeout := viper.GetDuration("deadlines." + stage); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/joelchen/gothumb/processor"
+	"github.com/joelchen/gothumb/signing"
+	"github.com/spf13/viper"
+)
+
+type sizeInfo struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Constraints on sizes requested as WIDTHxHEIGHT
+type dynamicInfo struct {
+	Widths  []int `json:"widths,omitempty"`
+	Heights []int `json:"heights,omitempty"`
+	Min     int   `json:"min"`
+	Max     int   `json:"max"`
+	Step    int   `json:"step"`
+}
+
+type discovery struct {
+	Version  string              `json:"version"`
+	Sizes    map[string]sizeInfo `json:"sizes"`
+	Dynamic  *dynamicInfo        `json:"dynamic,omitempty"`
+	Formats  []string            `json:"formats"`
+	Features features            `json:"features"`
+}
+
+type features struct {
+	Signature string `json:"signature"`
+	Expiry    bool   `json:"expiry"`
+	Download  bool   `json:"download"`
+	JWT       bool   `json:"jwt"`
+	HTTP3     bool   `json:"http3"`
+	Fallback  bool   `json:"fallback"`
+	Originals bool   `json:"originals"`
+	Hotlink   string `json:"hotlink,omitempty"`
+	Redirect  string `json:"redirect,omitempty"`
+}
+
+// Serves the configured sizes and capabilities at /discovery to callers
+// presenting discovery.token or, with jwt.enabled, a valid bearer token, so
+// build tooling can generate srcsets without duplicating the config
+func withDiscovery(next http.Handler) http.Handler {
+	if viper.GetString("discovery.token") == "" && !viper.GetBool("jwt.enabled") {
+		return next
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/discovery" {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		c, err := discoveryClient(request)
+
+		if err != nil {
+			auditFailure(request, 615, err)
+			httpError(writer, request, err, 615)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Cache-Control", "private, max-age=60")
+		json.NewEncoder(writer).Encode(describe(requestTenant(request), c))
+	})
+}
+
+// Returns the token's client, which is nil for the shared discovery token
+func discoveryClient(request *http.Request) (*client, error) {
+	raw := bearerToken(request)
+
+	if raw == "" {
+		return nil, fmt.Errorf("Missing token")
+	}
+
+	if token := viper.GetString("discovery.token"); token != "" && subtle.ConstantTimeCompare([]byte(raw), []byte(token)) == 1 {
+		return nil, nil
+	}
+
+	if !viper.GetBool("jwt.enabled") {
+		return nil, fmt.Errorf("Invalid token")
+	}
+
+	return parseToken(raw)
+}
+
+// Lists the sizes the client may request of the tenant and the features in
+// use
+func describe(t *tenant, c *client) *discovery {
+	info := &discovery{
+		Version: Version,
+		Sizes:   map[string]sizeInfo{},
+		Formats: []string{},
+		Features: features{
+			Signature: "query",
+			Expiry:    true,
+			Download:  true,
+			JWT:       viper.GetBool("jwt.enabled"),
+			HTTP3:     viper.GetBool("server.http3"),
+			Fallback:  viper.GetString("fallback.image") != "" || len(viper.GetStringMap("fallback.sizes")) > 0,
+			Redirect:  viper.GetString("redirect.mode"),
+			Originals: viper.GetBool("originals.enabled"),
+		},
+	}
+
+	for _, format := range processor.Default.Formats() {
+		info.Formats = append(info.Formats, "image/"+format)
+	}
+
+	switch {
+	case viper.GetBool("server.unsafe"):
+		info.Features.Signature = "unsafe"
+	case signing.ThumborMode():
+		info.Features.Signature = "thumbor"
+	case signing.ImgproxyMode():
+		info.Features.Signature = "imgproxy"
+	case signing.InPath():
+		info.Features.Signature = "path"
+	}
+
+	if len(viper.GetStringSlice("hotlink.allowed")) > 0 {
+		info.Features.Hotlink = viper.GetString("hotlink.action")
+	}
+
+	for _, name := range sizeNames(t) {
+		if c != nil && len(c.Sizes) > 0 && !containsString(c.Sizes, name) {
+			continue
+		}
+
+		if width, height, err := parseWidthAndHeight(t, name); err == nil {
+			info.Sizes[name] = sizeInfo{width, height}
+		}
+	}
+
+	// Clients limited to some sizes cannot request dynamic ones
+	if viper.GetBool("dynamic-sizes.enabled") && (c == nil || len(c.Sizes) == 0) {
+		info.Dynamic = &dynamicInfo{
+			Widths:  viper.GetIntSlice("dynamic-sizes.widths"),
+			Heights: viper.GetIntSlice("dynamic-sizes.heights"),
+			Min:     viper.GetInt("dynamic-sizes.min"),
+			Max:     viper.GetInt("dynamic-sizes.max"),
+			Step:    viper.GetInt("dynamic-sizes.step"),
+		}
+	}
+
+	return info
+}
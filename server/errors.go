@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/joelchen/gothumb/processor"
+	"github.com/joelchen/gothumb/source"
+	"github.com/spf13/viper"
+)
+
+var (
+	errBusy           = processor.ErrBusy
+	errSourceNotFound = source.ErrNotFound
+	errSourceTooLarge = source.ErrTooLarge
+	errRateLimited    = fmt.Errorf("Rate limit exceeded")
+)
+
+type errorCode struct {
+	Status int
+	Name   string
+}
+
+// Standard statuses and stable names for gothumb's internal error codes,
+// which are passed on in the X-Error-Code header
+var errorCodes = map[int]errorCode{
+	601: {http.StatusBadRequest, "invalid_size"},
+	602: {http.StatusForbidden, "signature_mismatch"},
+	603: {http.StatusBadRequest, "invalid_source"},
+	604: {http.StatusBadGateway, "source_unavailable"},
+	605: {http.StatusInternalServerError, "generation_failed"},
+	606: {http.StatusInternalServerError, "storage_unavailable"},
+	607: {http.StatusBadRequest, "invalid_source"},
+	608: {http.StatusBadGateway, "source_unavailable"},
+	609: {http.StatusInternalServerError, "generation_failed"},
+	610: {http.StatusBadGateway, "source_unavailable"},
+	611: {http.StatusInternalServerError, "write_failed"},
+	612: {http.StatusForbidden, "signature_expired"},
+	613: {http.StatusForbidden, "unknown_client"},
+	614: {http.StatusForbidden, "not_allowed"},
+	615: {http.StatusUnauthorized, "invalid_token"},
+	616: {http.StatusTooManyRequests, "rate_limited"},
+	617: {http.StatusForbidden, "hotlink"},
+	618: {http.StatusForbidden, "address_denied"},
+	619: {http.StatusInternalServerError, "redirect_failed"},
+	620: {http.StatusBadRequest, "invalid_download"},
+	621: {http.StatusTooManyRequests, "quota_exceeded"},
+	622: {http.StatusPaymentRequired, "quota_exceeded"},
+	623: {http.StatusServiceUnavailable, "budget_exceeded"},
+	624: {http.StatusBadRequest, "invalid_options"},
+}
+
+// Returned instead of the error's code for failures that mean the same
+// wherever they happen
+var errorOverrides = map[error]errorCode{
+	errBusy:           {http.StatusServiceUnavailable, "busy"},
+	source.ErrBusy:    {http.StatusServiceUnavailable, "busy"},
+	errSourceNotFound: {http.StatusNotFound, "source_not_found"},
+	errSourceTooLarge: {http.StatusRequestEntityTooLarge, "source_too_large"},
+	// Origins whose circuit breaker is open
+	source.ErrUnavailable: {http.StatusServiceUnavailable, "source_circuit_open"},
+	// Resizes that outlived server.request-timeout
+	context.DeadlineExceeded: {http.StatusGatewayTimeout, "timeout"},
+}
+
+func lookupError(err error, code int) errorCode {
+	if info, ok := errorOverrides[err]; ok {
+		return info
+	}
+
+	if info, ok := errorCodes[code]; ok {
+		return info
+	}
+
+	return errorCode{http.StatusInternalServerError, "internal"}
+}
+
+type errorBody struct {
+	Code         string `json:"code"`
+	InternalCode int    `json:"internal_code"`
+	Message      string `json:"message"`
+	RequestID    string `json:"request_id,omitempty"`
+}
+
+// Writes an error with a standard status as JSON, or as plain text to
+// clients asking for HTML. Details of server-side failures are logged
+// rather than returned.
+func httpError(writer http.ResponseWriter, request *http.Request, err error, code int) {
+	discardResponse(writer, request, err)
+	info := lookupError(err, code)
+	message := err.Error()
+
+	if info.Status >= 500 && info.Status != http.StatusServiceUnavailable {
+		logger.Ctx(request.Context()).Error("request failed", "path", request.URL.EscapedPath(), "error", err)
+		message = http.StatusText(info.Status)
+	}
+
+	// Set after the buffered response is discarded, which drops its headers
+	switch {
+	case err == errRateLimited:
+		writer.Header().Set("Retry-After", "1")
+	case err == errBudgetExceeded:
+		writer.Header().Set("Retry-After", strconv.Itoa(budgetRetryAfter()))
+	case info.Status == http.StatusServiceUnavailable:
+		writer.Header().Set("Retry-After", viper.GetString("concurrency.retry-after"))
+	}
+
+	writer.Header().Set("X-Error-Code", strconv.Itoa(code))
+
+	if strings.Contains(request.Header.Get("Accept"), "text/html") {
+		http.Error(writer, message, info.Status)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.WriteHeader(info.Status)
+	json.NewEncoder(writer).Encode(&errorBody{
+		Code:         info.Name,
+		InternalCode: code,
+		Message:      message,
+		RequestID:    requestID(request.Context()),
+	})
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/joelchen/gothumb/source"
+	"github.com/spf13/viper"
+)
+
+// Fallback images read from disk, by path
+var fallbacks = struct {
+	sync.Mutex
+	images map[string][]byte
+}{images: map[string][]byte{}}
+
+// Returns the placeholder configured for a size under fallback.sizes, or
+// fallback.image otherwise
+func fallbackImage(size string) ([]byte, error) {
+	file := viper.GetString("fallback.sizes." + size)
+
+	if file == "" {
+		file = viper.GetString("fallback.image")
+	}
+
+	if file == "" {
+		return nil, nil
+	}
+
+	fallbacks.Lock()
+	defer fallbacks.Unlock()
+
+	if data, ok := fallbacks.images[file]; ok {
+		return data, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fallbacks.images[file] = data
+	return data, nil
+}
+
+// Whether a failure means the source is missing or could not be decoded
+func wantsFallback(err error, code int) bool {
+	switch {
+	case err == errSourceNotFound || err == source.ErrUnavailable:
+		return true
+	case err == errBusy || err == source.ErrBusy || err == errSourceTooLarge:
+		return false
+	default:
+		return code == 605 || code == 609
+	}
+}
+
+// Writes a thumbnail failure, serving the fallback image for the size with
+// the error's status and a short max-age when one is configured
+func thumbnailError(writer http.ResponseWriter, request *http.Request, size string, err error, code int) {
+	if !wantsFallback(err, code) {
+		httpError(writer, request, err, code)
+		return
+	}
+
+	data, ferr := fallbackImage(size)
+
+	if ferr != nil {
+		logger.Ctx(request.Context()).Error("fallback image failed", "error", ferr)
+	}
+
+	if data == nil {
+		httpError(writer, request, err, code)
+		return
+	}
+
+	discardResponse(writer, request, err)
+	info := lookupError(err, code)
+
+	if info.Status >= 500 {
+		logger.Ctx(request.Context()).Error("request failed", "path", request.URL.EscapedPath(), "error", err)
+	}
+
+	writer.Header().Set("X-Error-Code", strconv.Itoa(code))
+	writer.Header().Set("Content-Type", http.DetectContentType(data))
+	writer.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	writer.Header().Set("Cache-Control", "public, max-age="+viper.GetString("fallback.max-age"))
+	writer.WriteHeader(info.Status)
+
+	if request.Method != "HEAD" {
+		writer.Write(data)
+	}
+}
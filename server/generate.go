@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/joelchen/gothumb/source"
+	"github.com/joelchen/gothumb/storage"
+)
+
+// Sizes returns the names of all configured sizes, sorted
+func Sizes() []string {
+	return sizeNames(nil)
+}
+
+// Generate renders the sizes of a source into the cache bucket, as requests
+// for them would, skipping sizes already cached unless force is set. The
+// source is a key in the bucket or a URL, as in a thumbnail path.
+func Generate(ctx context.Context, sourcePath string, sizes []string, force bool) error {
+	return generate(ctx, nil, sourcePath, sizes, force, fetchOriginal)
+}
+
+// GenerateImage renders the sizes of an original already in memory into
+// the cache bucket under the paths requests for sourcePath would look up,
+// replacing any cached ones
+func GenerateImage(ctx context.Context, sourcePath string, data []byte, validators source.Validators, sizes []string) error {
+	return generate(ctx, nil, sourcePath, sizes, true, func(ctx context.Context, t *tenant, sourceURL *url.URL) (io.ReadCloser, source.Validators, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), validators, nil
+	})
+}
+
+// Renders the sizes of a tenant's source, or of the top-level config's for
+// a nil tenant
+func generate(ctx context.Context, t *tenant, sourcePath string, sizes []string, force bool, fetch func(context.Context, *tenant, *url.URL) (io.ReadCloser, source.Validators, error)) error {
+	if storage.Bucket() == "" {
+		return fmt.Errorf("No cache bucket configured")
+	}
+
+	sourceURL, err := url.Parse(strings.TrimPrefix(sourcePath, "/"))
+
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	var thumbs []thumbnail
+
+	for _, size := range sizes {
+		width, height, err := parseWidthAndHeight(t, size)
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", size, err)
+		}
+
+		thumb := thumbnail{Tenant: t, Source: sourcePath, Size: size, Width: width, Height: height}
+		resultPath := t.cachePath(sourceURL, thumb.variant())
+
+		if !force && cached(ctx, resultPath) {
+			continue
+		}
+
+		paths = append(paths, resultPath)
+		thumbs = append(thumbs, thumb)
+	}
+
+	// The original is only fetched once some size needs it, and decoded
+	// once for all of them
+	if len(thumbs) == 0 {
+		return nil
+	}
+
+	if err := checkBudget(); err != nil {
+		return err
+	}
+
+	body, validators, err := fetch(ctx, t, sourceURL)
+
+	if err != nil {
+		return err
+	}
+
+	results, err := processThumbnails(ctx, body, paths, thumbs, validators)
+
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if err = putResult(ctx, result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Render resizes an image to a size, and into a format unless it is empty,
+// as a request would but without caching the result or reporting it to
+// webhooks
+func Render(ctx context.Context, image []byte, size, format string) ([]byte, string, error) {
+	width, height, err := parseWidthAndHeight(nil, size)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", size, err)
+	}
+
+	thumb := thumbnail{Size: size, Width: width, Height: height, Format: format}
+	result, err := processImage(ctx, ioutil.NopCloser(bytes.NewReader(image)), "", thumb, source.Validators{})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return result.Data, result.ContentType, nil
+}
+
+func cached(ctx context.Context, path string) bool {
+	ctx, cancel := stageTimeout(ctx, "cache-read")
+	defer cancel()
+
+	_, err := storage.Service().HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(storage.Bucket()),
+		Key:    aws.String(path),
+	})
+
+	return err == nil
+}
+
+// Opens a source from the tenant's bucket when it has no host, or from its
+// URL. The body is read with source.Read by whoever renders it, so it is
+// held to source.max-size and the memory budget like a request's.
+func fetchOriginal(ctx context.Context, t *tenant, sourceURL *url.URL) (io.ReadCloser, source.Validators, error) {
+	if sourceURL.Host != "" {
+		return source.Fetch(ctx, sourceURL.String(), source.Validators{})
+	}
+
+	if t.sourceBucket() == "" {
+		return nil, source.Validators{}, fmt.Errorf("Source has no host and no bucket is configured")
+	}
+
+	ctx, cancel := source.FetchContext(ctx)
+
+	output, err := storage.Service().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(t.sourceBucket()),
+		Key:    aws.String(sourceURL.Path),
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		err = source.ErrNotFound
+	}
+
+	if err != nil {
+		cancel()
+		return nil, source.Validators{}, err
+	}
+
+	return &source.Sized{ReadCloser: source.CancelOnClose(output.Body, cancel), Length: aws.Int64Value(output.ContentLength)}, source.Validators{}, nil
+}
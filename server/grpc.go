@@ -0,0 +1,399 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/joelchen/gothumb/processor"
+	"github.com/joelchen/gothumb/storage"
+	"github.com/joelchen/gothumb/thumbpb"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Listener serving the gRPC API when grpc.address is set
+var grpcServer *grpc.Server
+
+// Bytes of thumbnail data sent per message of a Resize stream
+const grpcChunkSize = 64 * 1024
+
+type grpcClientKey struct{}
+
+// Serves the gRPC API on grpc.address, with the server's TLS config if any
+func serveGRPC(tlsConfig *tls.Config) error {
+	address := viper.GetString("grpc.address")
+
+	if address == "" {
+		return nil
+	}
+
+	options := []grpc.ServerOption{
+		grpc.UnaryInterceptor(authorizeUnary),
+		grpc.StreamInterceptor(authorizeStream),
+	}
+
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig.Clone())))
+	}
+
+	listener, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return err
+	}
+
+	grpcServer = grpc.NewServer(options...)
+	thumbpb.RegisterThumbnailsServer(grpcServer, &thumbnailService{})
+
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			listenerFailed(err)
+		}
+	}()
+
+	return nil
+}
+
+// Accepts calls bearing grpc.token, which may do anything, or with
+// jwt.enabled a token whose client is then held to its sizes and sources
+func grpcClient(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var raw string
+
+	if values := md.Get("authorization"); len(values) > 0 && len(values[0]) > 7 && strings.EqualFold(values[0][:7], "Bearer ") {
+		raw = values[0][7:]
+	}
+
+	if raw == "" {
+		return nil, status.Error(codes.Unauthenticated, "Missing token")
+	}
+
+	if token := viper.GetString("grpc.token"); token != "" && subtle.ConstantTimeCompare([]byte(raw), []byte(token)) == 1 {
+		return ctx, nil
+	}
+
+	if !viper.GetBool("jwt.enabled") {
+		return nil, status.Error(codes.Unauthenticated, "Invalid token")
+	}
+
+	c, err := parseToken(raw)
+
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return context.WithValue(ctx, grpcClientKey{}, c), nil
+}
+
+func authorizeUnary(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := grpcClient(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, request)
+}
+
+func authorizeStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := grpcClient(stream.Context())
+
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &authorizedStream{stream, ctx})
+}
+
+// A server stream carrying the authorized client in its context
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// Checks a token's client may request the size and source; callers with
+// grpc.token may request anything
+func grpcAllows(ctx context.Context, size, source string) error {
+	if c, ok := ctx.Value(grpcClientKey{}).(*client); ok {
+		if err := c.allows(size, source); err != nil {
+			return status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Maps a pipeline error onto the gRPC code matching its HTTP status
+func grpcError(err error, code int) error {
+	info := lookupError(err, code)
+	message := err.Error()
+
+	if info.Status >= 500 && info.Status != http.StatusServiceUnavailable && info.Status != http.StatusGatewayTimeout {
+		logger.Errorf("%v", err)
+		message = http.StatusText(info.Status)
+	}
+
+	switch info.Status {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, message)
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, message)
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, message)
+	case http.StatusRequestEntityTooLarge:
+		return status.Error(codes.ResourceExhausted, message)
+	case http.StatusServiceUnavailable:
+		return status.Error(codes.Unavailable, message)
+	case http.StatusGatewayTimeout:
+		return status.Error(codes.DeadlineExceeded, message)
+	default:
+		return status.Error(codes.Internal, message)
+	}
+}
+
+type thumbnailService struct {
+	thumbpb.UnimplementedThumbnailsServer
+}
+
+func (s *thumbnailService) Resize(request *thumbpb.ResizeRequest, stream thumbpb.Thumbnails_ResizeServer) error {
+	ctx := stream.Context()
+	size := resolveSize(nil, request.Size)
+
+	if err := grpcAllows(ctx, size, request.Source); err != nil {
+		return err
+	}
+
+	result, code, err := thumbnailResult(ctx, request.Source, size, request.Format)
+
+	if err != nil {
+		return grpcError(err, code)
+	}
+
+	chunk := &thumbpb.ResizeChunk{
+		ContentType:   result.ContentType,
+		ContentLength: result.ContentLength,
+		Etag:          result.ETag,
+	}
+
+	data := result.Data
+
+	for {
+		n := len(data)
+
+		if n > grpcChunkSize {
+			n = grpcChunkSize
+		}
+
+		chunk.Data, data = data[:n], data[n:]
+
+		if err := stream.Send(chunk); err != nil {
+			return err
+		}
+
+		if len(data) == 0 {
+			return nil
+		}
+
+		chunk = &thumbpb.ResizeChunk{}
+	}
+}
+
+// Returns a thumbnail from the cache bucket, or generates it and stores it
+// in the background, along with the error code of a failure
+func thumbnailResult(ctx context.Context, sourcePath, size, format string) (*result, int, error) {
+	width, height, err := parseWidthAndHeight(nil, size)
+
+	if err != nil {
+		return nil, 601, err
+	}
+
+	if format != "" && !containsString(processor.Default.Formats(), format) {
+		return nil, 601, fmt.Errorf("Unsupported format: %s", format)
+	}
+
+	sourceURL, err := url.Parse(strings.TrimPrefix(sourcePath, "/"))
+
+	if err != nil {
+		return nil, 603, err
+	}
+
+	thumb := thumbnail{Source: sourcePath, Size: size, Width: width, Height: height, Format: format}
+	resultPath := cachePath(sourceURL, thumb.variant())
+
+	if result, ok := cachedResult(ctx, nil, resultPath, size); ok {
+		return result, 0, nil
+	}
+
+	if err := checkBudget(); err != nil {
+		return nil, 623, err
+	}
+
+	body, validators, err := fetchOriginal(ctx, nil, sourceURL)
+
+	if err != nil {
+		return nil, 604, err
+	}
+
+	result, err := renderThumbnail(ctx, body, resultPath, thumb, validators)
+
+	if err != nil {
+		return nil, 605, err
+	}
+
+	return result, 0, nil
+}
+
+// Reads a thumbnail from the cache bucket for the tenant, whose caching
+// headers it is then served with, reporting false when there is no bucket
+// or it is not cached
+func cachedResult(ctx context.Context, t *tenant, path, size string) (*result, bool) {
+	if storage.Bucket() == "" {
+		return nil, false
+	}
+
+	ctx, cancel := stageTimeout(ctx, "cache-read")
+	defer cancel()
+
+	output, err := storage.Service().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(storage.Bucket()),
+		Key:    aws.String(path),
+	}, storage.RequestID(ctx))
+
+	if err != nil {
+		return nil, false
+	}
+
+	defer output.Body.Close()
+	data, err := ioutil.ReadAll(output.Body)
+
+	if err != nil {
+		return nil, false
+	}
+
+	return &result{
+		Data:          data,
+		ContentType:   aws.StringValue(output.ContentType),
+		ContentLength: int64(len(data)),
+		ETag:          strings.Trim(aws.StringValue(output.ETag), `"`),
+		LastModified:  cachedLastModified(output.Metadata, output.LastModified),
+		Path:          path,
+		Size:          size,
+		Tenant:        t,
+	}, true
+}
+
+func (s *thumbnailService) GetInfo(ctx context.Context, request *thumbpb.GetInfoRequest) (*thumbpb.GetInfoResponse, error) {
+	c, _ := ctx.Value(grpcClientKey{}).(*client)
+	info := describe(nil, c)
+	response := &thumbpb.GetInfoResponse{Build: buildInfo(), Formats: info.Formats}
+
+	for _, name := range Sizes() {
+		if size, ok := info.Sizes[name]; ok {
+			response.Sizes = append(response.Sizes, &thumbpb.Size{Name: name, Width: int32(size.Width), Height: int32(size.Height)})
+		}
+	}
+
+	return response, nil
+}
+
+func (s *thumbnailService) Purge(ctx context.Context, request *thumbpb.PurgeRequest) (*thumbpb.PurgeResponse, error) {
+	if _, ok := ctx.Value(grpcClientKey{}).(*client); ok {
+		return nil, status.Error(codes.PermissionDenied, "Purging requires grpc.token")
+	}
+
+	if storage.Bucket() == "" {
+		return nil, status.Error(codes.FailedPrecondition, "No cache bucket configured")
+	}
+
+	source, err := url.Parse(strings.TrimPrefix(request.Source, "/"))
+
+	if err != nil || source.Path == "" {
+		return nil, status.Error(codes.InvalidArgument, "Invalid source")
+	}
+
+	keys, err := purge(ctx, nil, source, request.Sizes)
+
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &thumbpb.PurgeResponse{Purged: keys}, nil
+}
+
+func (s *thumbnailService) Pregenerate(request *thumbpb.PregenerateRequest, stream thumbpb.Thumbnails_PregenerateServer) error {
+	ctx := stream.Context()
+	sizes := request.Sizes
+
+	if len(sizes) == 0 {
+		sizes = Sizes()
+
+		// Clients limited to some sizes pregenerate those
+		if c, ok := ctx.Value(grpcClientKey{}).(*client); ok && len(c.Sizes) > 0 {
+			sizes = c.Sizes
+		}
+	}
+
+	resolved := make([]string, len(sizes))
+
+	for i, size := range sizes {
+		resolved[i] = resolveSize(nil, size)
+	}
+
+	for _, source := range request.Sources {
+		result := &thumbpb.PregenerateResult{Source: source}
+		var err error
+
+		for _, size := range resolved {
+			if err = grpcAllows(ctx, size, source); err != nil {
+				break
+			}
+		}
+
+		if err == nil {
+			err = Generate(ctx, source, resolved, request.Force)
+		}
+
+		if err != nil {
+			result.Error = status.Convert(err).Message()
+		}
+
+		if err := stream.Send(result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Lets in-flight calls finish until the context is done, then cancels them
+func stopGRPC(ctx context.Context) {
+	stopped := make(chan struct{})
+
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		grpcServer.Stop()
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Returns a cache-control setting for the tenant's size, preferring the
+// max-age of its sizes entry, then the value under
+// cache-control.sizes.<size> to the global one
+func cacheSetting(t *tenant, size, key string) string {
+	if key == "max-age" && sizeOption(t, size, key) != nil {
+		return cast.ToString(sizeOption(t, size, key))
+	}
+
+	if sizeKey := t.key("cache-control.sizes." + size + "." + key); size != "" && viper.IsSet(sizeKey) {
+		return viper.GetString(sizeKey)
+	}
+
+	return viper.GetString(t.key("cache-control." + key))
+}
+
+// Builds the Cache-Control value from max-age, s-maxage, private, immutable,
+// stale-while-revalidate and stale-if-error, each configurable per size
+func cacheControl(t *tenant, size string) string {
+	visibility := "public"
+
+	if cast.ToBool(cacheSetting(t, size, "private")) {
+		visibility = "private"
+	}
+
+	directives := []string{"max-age=" + strconv.Itoa(cast.ToInt(cacheSetting(t, size, "max-age"))), visibility}
+
+	for _, key := range []string{"s-maxage", "stale-while-revalidate", "stale-if-error"} {
+		if value := cast.ToInt(cacheSetting(t, size, key)); value > 0 {
+			directives = append(directives, key+"="+strconv.Itoa(value))
+		}
+	}
+
+	if cast.ToBool(cacheSetting(t, size, "immutable")) {
+		directives = append(directives, "immutable")
+	}
+
+	return strings.Join(directives, ",")
+}
+
+func setCacheHeaders(w http.ResponseWriter, t *tenant, size string) {
+	w.Header().Set("Cache-Control", cacheControl(t, size))
+	setVaryHeaders(w)
+}
+
+// Lists the request headers that select between variants of a response so
+// shared caches keep them apart: Accept when formats are negotiated, and any
+// fields named in cache-control.vary, such as DPR and Width for deployments
+// handling client hints in front of gothumb.
+func setVaryHeaders(w http.ResponseWriter) {
+	if len(viper.GetStringSlice("formats.negotiate")) > 0 {
+		w.Header().Add("Vary", "Accept")
+	}
+
+	for _, field := range viper.GetStringSlice("cache-control.vary") {
+		w.Header().Add("Vary", field)
+	}
+}
+
+func setResultHeaders(w http.ResponseWriter, result *result) {
+	w.Header().Set("Content-Type", result.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(result.ContentLength, 10))
+	w.Header().Set("ETag", `"`+result.ETag+`"`)
+
+	if !result.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", result.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	setCacheHeaders(w, result.Tenant, result.Size)
+}
+
+// Tags the response so a CDN can purge every variant of a source at once.
+// cache-control.surrogate-keys picks which of "source" (a hash of the
+// source path), "size" and "all" are sent, in the headers named by
+// cache-control.surrogate-headers.
+func setSurrogateKeys(w http.ResponseWriter, source, size string) {
+	var keys []string
+
+	for _, kind := range viper.GetStringSlice("cache-control.surrogate-keys") {
+		switch kind {
+		case "source":
+			keys = append(keys, "src-"+computeHexMD5([]byte(source)))
+		case "size":
+			keys = append(keys, "size-"+size)
+		case "all":
+			keys = append(keys, "gothumb")
+		}
+	}
+
+	if len(keys) == 0 {
+		return
+	}
+
+	for _, name := range viper.GetStringSlice("cache-control.surrogate-headers") {
+		separator := " "
+
+		// Cloudflare expects Cache-Tag values to be comma separated
+		if strings.EqualFold(name, "Cache-Tag") {
+			separator = ","
+		}
+
+		w.Header().Set(name, strings.Join(keys, separator))
+	}
+}
+
+// Marks the response as an attachment when the request names a download
+// filename, keeping only the base name without control characters or quotes
+func setDownloadHeader(w http.ResponseWriter, request *http.Request) error {
+	download := request.URL.Query().Get("download")
+
+	if download == "" {
+		return nil
+	}
+
+	filename := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' || r == '\\' {
+			return -1
+		}
+
+		return r
+	}, path.Base(strings.Replace(download, "\\", "/", -1)))
+
+	if filename == "" || filename == "." || filename == "/" {
+		return fmt.Errorf("Invalid download filename")
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	return nil
+}
+
+// Says how a thumbnail was served when server.debug-headers is set: whether
+// it came from the hot cache, the bucket or was generated, at which size,
+// and the stage timings in Server-Timing
+func setDebugHeaders(request *http.Request, header http.Header) {
+	if !viper.GetBool("server.debug-headers") {
+		return
+	}
+
+	access := accessInfo(request)
+
+	if access.Cache != "" {
+		header.Set("X-Gothumb-Cache", access.Cache)
+	}
+
+	if dimensions, ok := sizeDimensions(requestTenant(request), access.Size); ok {
+		header.Set("X-Gothumb-Size", access.Size+" "+dimensions)
+	} else if access.Size != "" {
+		header.Set("X-Gothumb-Size", access.Size)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/joelchen/gothumb/processor"
+	"github.com/joelchen/gothumb/signing"
+	"github.com/joelchen/gothumb/storage"
+	"github.com/spf13/viper"
+)
+
+// Version, Commit and BuildDate are set at build time with -ldflags, see
+// the README
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+var startTime = time.Now()
+
+// Serves unauthenticated endpoints for load balancers and orchestrators
+// ahead of the IP filter and image routes. With server.health-routes off
+// they are only served on the admin listener.
+func withSystemRoutes(next http.Handler) http.Handler {
+	if !viper.GetBool("server.health-routes") {
+		return next
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/healthz":
+			handleHealth(writer, request)
+		case "/readyz":
+			handleReady(writer, request)
+		case "/version":
+			handleVersion(writer, request)
+		default:
+			next.ServeHTTP(writer, request)
+		}
+	})
+}
+
+// Reports liveness without touching S3 or libvips
+func handleHealth(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"status":  "ok",
+		"version": Version,
+		"commit":  Commit,
+		"uptime":  int64(time.Since(startTime).Seconds()),
+	})
+}
+
+func buildInfo() map[string]string {
+	return map[string]string{
+		"version":    Version,
+		"commit":     Commit,
+		"build_date": BuildDate,
+		"processor":  processor.Default.Version(),
+		"go":         runtime.Version(),
+	}
+}
+
+// Reports which build is running
+func handleVersion(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(writer).Encode(buildInfo())
+}
+
+// Checks that libvips can process an image, a signing method is configured
+// and the cache bucket is reachable
+func readinessChecks() map[string]string {
+	checks := map[string]string{"vips": "ok", "signing": "ok"}
+
+	if err := processor.Check(); err != nil {
+		checks["vips"] = err.Error()
+	}
+
+	if len(signing.Keys()) == 0 && !current().keyOptional() {
+		checks["signing"] = "no signing key loaded"
+	}
+
+	if isDraining() {
+		checks["shutdown"] = "draining"
+	}
+
+	if storage.Bucket() != "" {
+		checks["s3"] = "ok"
+
+		if err := checkBucket(); err != nil {
+			checks["s3"] = err.Error()
+		}
+	}
+
+	return checks
+}
+
+func checkBucket() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := storage.Service().HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(storage.Bucket()),
+	})
+
+	return err
+}
+
+// Replies 503 until every readiness check passes
+func handleReady(writer http.ResponseWriter, request *http.Request) {
+	checks := readinessChecks()
+	status := http.StatusOK
+
+	for _, result := range checks {
+		if result != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(checks)
+}
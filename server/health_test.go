@@ -0,0 +1,37 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestReadinessSigning(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		ready    bool
+	}{
+		{"server key", nil, true},
+		{"no key", map[string]interface{}{"server.key": ""}, false},
+		{"unsafe", map[string]interface{}{"server.key": "", "server.unsafe": true}, true},
+		{"jwt", map[string]interface{}{"server.key": "", "jwt.enabled": true, "jwt.secret": "secret"}, true},
+		{"clients", map[string]interface{}{"server.key": "", "clients.app.secret": "0123456789abcdef"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testConfig(t, test.settings)
+
+			if err := Validate(); (err == nil) != test.ready {
+				t.Fatalf("Validate() = %v", err)
+			}
+
+			if got := readinessChecks()["signing"]; (got == "ok") != test.ready {
+				t.Errorf("signing check %q, want ready %v", got, test.ready)
+			}
+		})
+	}
+
+	viper.Reset()
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Recently served thumbnails kept in memory by cache path, bounded by
+// hot-cache.entries and hot-cache.size and expiring after hot-cache.ttl, so
+// repeated requests skip S3 while an upload is pending or the CDN misses
+var hotResults = &hotCache{entries: map[string]*list.Element{}, order: list.New()}
+
+type hotCache struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	// Most recently used first
+	order *list.List
+	bytes int64
+}
+
+type hotEntry struct {
+	result  *result
+	expires time.Time
+}
+
+func hotCacheEnabled() bool {
+	return viper.GetInt("hot-cache.entries") > 0
+}
+
+func (c *hotCache) get(path string) (*result, bool) {
+	if !hotCacheEnabled() {
+		return nil, false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.entries[path]
+
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*hotEntry)
+
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.result, true
+}
+
+// Whether a result of this many bytes may be kept
+func (c *hotCache) fits(length int64) bool {
+	return hotCacheEnabled() && length <= int64(viper.GetSizeInBytes("hot-cache.size"))
+}
+
+func (c *hotCache) add(result *result) {
+	if !c.fits(result.ContentLength) || result.Data == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.entries[result.Path]; ok {
+		c.remove(element)
+	}
+
+	c.entries[result.Path] = c.order.PushFront(&hotEntry{result, time.Now().Add(viper.GetDuration("hot-cache.ttl"))})
+	c.bytes += result.ContentLength
+	maxEntries := viper.GetInt("hot-cache.entries")
+	maxBytes := int64(viper.GetSizeInBytes("hot-cache.size"))
+
+	for c.order.Len() > maxEntries || c.bytes > maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// Evicts the thumbnails at the paths
+func (c *hotCache) evict(paths []string) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, path := range paths {
+		if element, ok := c.entries[path]; ok {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *hotCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*hotEntry)
+	delete(c.entries, entry.result.Path)
+	c.bytes -= entry.result.ContentLength
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+var watermark struct {
+	sync.Once
+	image image.Image
+	err   error
+}
+
+// Reports whether the Referer or Origin host matches one of the patterns in
+// hotlink.allowed, such as "*.example.com"
+func refererMatches(request *http.Request) bool {
+	referer := request.Header.Get("Referer")
+
+	if referer == "" {
+		referer = request.Header.Get("Origin")
+	}
+
+	if referer == "" {
+		return false
+	}
+
+	u, err := url.Parse(referer)
+
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range viper.GetStringSlice("hotlink.allowed") {
+		if ok, _ := path.Match(pattern, u.Hostname()); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether the request passes hotlink protection. Protection is off
+// unless hotlink.allowed is set, and requests without a Referer or Origin
+// pass when hotlink.allow-empty is set.
+func refererAllowed(request *http.Request) bool {
+	if len(viper.GetStringSlice("hotlink.allowed")) == 0 {
+		return true
+	}
+
+	if request.Header.Get("Referer") == "" && request.Header.Get("Origin") == "" {
+		return viper.GetBool("hotlink.allow-empty")
+	}
+
+	return refererMatches(request)
+}
+
+// Applies hotlink.action to a thumbnail requested from a foreign site:
+// "deny" rejects it, "low-res" serves hotlink.low-res-size instead and
+// "watermark" overlays hotlink.watermark
+func hotlinkThumbnail(thumb thumbnail) (thumbnail, error) {
+	switch viper.GetString("hotlink.action") {
+	case "low-res":
+		size := viper.GetString("hotlink.low-res-size")
+		width, height, err := parseWidthAndHeight(thumb.Tenant, size)
+
+		if err != nil {
+			return thumb, err
+		}
+
+		return thumbnail{Tenant: thumb.Tenant, Source: thumb.Source, Size: size, Width: width, Height: height}, nil
+	case "watermark":
+		thumb.Watermark = true
+		return thumb, nil
+	default:
+		return thumb, fmt.Errorf("Hotlinking not allowed")
+	}
+}
+
+func loadWatermark() (image.Image, error) {
+	watermark.Do(func() {
+		file, err := os.Open(viper.GetString("hotlink.watermark"))
+
+		if err != nil {
+			watermark.err = err
+			return
+		}
+
+		defer file.Close()
+		watermark.image, _, watermark.err = image.Decode(file)
+	})
+
+	return watermark.image, watermark.err
+}
+
+// Watermarks every thumbnail when listed in the operations setting
+func watermarkOperation(data []byte, contentType string) ([]byte, string, error) {
+	return applyWatermark(data, contentType)
+}
+
+// Draws the watermark in the bottom right corner of an encoded image,
+// returning it with the content type it was encoded as
+func applyWatermark(buf []byte, contentType string) ([]byte, string, error) {
+	mark, err := loadWatermark()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(buf))
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	bounds := img.Bounds()
+	canvas := image.NewRGBA(bounds)
+	draw.Draw(canvas, bounds, img, bounds.Min, draw.Src)
+
+	size := mark.Bounds().Size()
+	offset := bounds.Max.Sub(size).Sub(image.Pt(10, 10))
+	draw.Draw(canvas, image.Rectangle{Min: offset, Max: offset.Add(size)}, mark, mark.Bounds().Min, draw.Over)
+	return encodeDrawn(canvas, contentType, viper.GetInt("vips.quality"))
+}
+
+// Encodes an image drawn with the standard library as PNG when it was one,
+// and as JPEG otherwise, returning the content type written
+func encodeDrawn(img image.Image, contentType string, quality int) ([]byte, string, error) {
+	var out bytes.Buffer
+
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+
+	if contentType == "image/png" {
+		err := png.Encode(&out, img)
+		return out.Bytes(), contentType, err
+	}
+
+	err := jpeg.Encode(&out, img, &jpeg.Options{Quality: quality})
+	return out.Bytes(), "image/jpeg", err
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/joelchen/gothumb/processor"
+	"github.com/julienschmidt/httprouter"
+)
+
+// imgproxy gravities the processors can crop around
+var imgproxyGravities = map[string]string{
+	"ce": "centre",
+	"sm": "smart",
+}
+
+// Reads an imgproxy path into thumbnail params, with the signature given and
+// the WIDTHxHEIGHT asked for as the size, and the options it sets. Sources
+// are either base64url encoded, optionally followed by .extension, or
+// given as plain/source@extension with the source percent-encoded.
+// Without them, options default to imgproxy's: fit within the size, crop
+// around the centre and never enlarge.
+func imgproxyParams(request *http.Request, signature string) (httprouter.Params, *urlOptions, error) {
+	// The signature segment, which imgproxy does not sign
+	segments := strings.Split(strings.TrimPrefix(request.URL.EscapedPath(), "/"), "/")[1:]
+	options := &urlOptions{Gravity: "centre"}
+	var width, height int
+	resizing := "fit"
+	i := 0
+
+	for ; i < len(segments) && strings.Contains(segments[i], ":"); i++ {
+		args := strings.Split(segments[i], ":")
+		var err error
+
+		switch name := args[0]; name {
+		case "resize", "rs":
+			resizing = imgproxyArg(args, 1, resizing)
+
+			if width, err = imgproxyInt(args, 2, width); err == nil {
+				height, err = imgproxyInt(args, 3, height)
+			}
+
+			if err == nil {
+				options.Enlarge, err = imgproxyBool(args, 4, options.Enlarge)
+			}
+
+			if err == nil {
+				err = imgproxyNoExtend(args, 5)
+			}
+		case "size", "s":
+			if width, err = imgproxyInt(args, 1, width); err == nil {
+				height, err = imgproxyInt(args, 2, height)
+			}
+
+			if err == nil {
+				options.Enlarge, err = imgproxyBool(args, 3, options.Enlarge)
+			}
+
+			if err == nil {
+				err = imgproxyNoExtend(args, 4)
+			}
+		case "resizing_type", "rt":
+			resizing = imgproxyArg(args, 1, resizing)
+		case "width", "w":
+			width, err = imgproxyInt(args, 1, width)
+		case "height", "h":
+			height, err = imgproxyInt(args, 1, height)
+		case "gravity", "g":
+			gravity, ok := imgproxyGravities[imgproxyArg(args, 1, "ce")]
+
+			if !ok {
+				return nil, nil, fmt.Errorf("Unsupported gravity: %s", args[1])
+			}
+
+			options.Gravity = gravity
+		case "enlarge", "el":
+			options.Enlarge, err = imgproxyBool(args, 1, options.Enlarge)
+		case "extend", "ex":
+			err = imgproxyNoExtend(args, 1)
+		case "quality", "q":
+			options.Quality, err = imgproxyInt(args, 1, options.Quality)
+		case "format", "f", "ext":
+			options.Format = imgproxyArg(args, 1, options.Format)
+		default:
+			return nil, nil, fmt.Errorf("Unsupported processing option: %s", name)
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", segments[i], err)
+		}
+	}
+
+	switch resizing {
+	case "fit":
+		options.Crop = false
+	case "fill":
+		options.Crop = true
+	case "fill-down":
+		options.Crop, options.Enlarge = true, false
+	default:
+		return nil, nil, fmt.Errorf("Unsupported resizing type: %s", resizing)
+	}
+
+	source, extension, err := imgproxySource(segments[i:])
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if extension != "" {
+		options.Format = extension
+	}
+
+	if options.Format == "jpg" {
+		options.Format = "jpeg"
+	}
+
+	if options.Format != "" && !containsString(processor.Default.Formats(), options.Format) {
+		return nil, nil, fmt.Errorf("Unsupported format: %s", options.Format)
+	}
+
+	return httprouter.Params{
+		{Key: "signature", Value: signature},
+		{Key: "size", Value: fmt.Sprintf("%dx%d", width, height)},
+		{Key: "source", Value: "/" + source},
+	}, options, nil
+}
+
+// Decodes the source from the segments after the processing options,
+// returning it with the extension given, if any
+func imgproxySource(segments []string) (string, string, error) {
+	if len(segments) == 0 {
+		return "", "", fmt.Errorf("Missing source")
+	}
+
+	if segments[0] == "plain" {
+		plain := strings.Join(segments[1:], "/")
+		var extension string
+
+		if at := strings.LastIndex(plain, "@"); at >= 0 {
+			plain, extension = plain[:at], plain[at+1:]
+		}
+
+		source, err := url.PathUnescape(plain)
+
+		if err != nil || source == "" {
+			return "", "", fmt.Errorf("Invalid source")
+		}
+
+		return source, extension, nil
+	}
+
+	// Long encoded sources may be split into segments
+	encoded := strings.Join(segments, "")
+	var extension string
+
+	if dot := strings.LastIndex(encoded, "."); dot >= 0 {
+		encoded, extension = encoded[:dot], encoded[dot+1:]
+	}
+
+	source, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+
+	if err != nil || len(source) == 0 {
+		return "", "", fmt.Errorf("Invalid source")
+	}
+
+	return string(source), extension, nil
+}
+
+// Returns an option's argument, or the fallback when it is left out or
+// empty
+func imgproxyArg(args []string, i int, fallback string) string {
+	if i >= len(args) || args[i] == "" {
+		return fallback
+	}
+
+	return args[i]
+}
+
+func imgproxyInt(args []string, i int, fallback int) (int, error) {
+	arg := imgproxyArg(args, i, "")
+
+	if arg == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(arg)
+
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a number", arg)
+	}
+
+	return n, nil
+}
+
+func imgproxyBool(args []string, i int, fallback bool) (bool, error) {
+	switch imgproxyArg(args, i, "") {
+	case "":
+		return fallback, nil
+	case "1", "t", "true":
+		return true, nil
+	case "0", "f", "false":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("%q is not a boolean", args[i])
+}
+
+// Extending images onto a background is not supported, so only accepts an
+// extend argument turning it off
+func imgproxyNoExtend(args []string, i int) error {
+	extend, err := imgproxyBool(args, i, false)
+
+	if err == nil && extend {
+		err = fmt.Errorf("extending is not supported")
+	}
+
+	return err
+}
+
+// Returns the processing options that ask for a size the way its config
+// renders it, for signing imgproxy URLs by size name. Options given as such,
+// containing a colon, are returned as they are.
+func imgproxyOptions(size string) (string, error) {
+	if strings.Contains(size, ":") {
+		return size, nil
+	}
+
+	width, height, err := parseWidthAndHeight(nil, size)
+
+	if err != nil {
+		return "", err
+	}
+
+	options := thumbnailOptions(thumbnail{Size: size, Width: width, Height: height})
+	resizing := "fit"
+
+	if options.Crop {
+		resizing = "fill"
+	}
+
+	parts := []string{fmt.Sprintf("rs:%s:%d:%d", resizing, width, height)}
+
+	for short, gravity := range imgproxyGravities {
+		if options.Crop && gravity == options.Gravity && short != "ce" {
+			parts = append(parts, "g:"+short)
+		}
+	}
+
+	if options.Enlarge {
+		parts = append(parts, "el:1")
+	}
+
+	return strings.Join(parts, "/"), nil
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/viper"
+)
+
+var tokenKey interface{}
+
+// Claims accepted in bearer tokens, restricting the sizes and source
+// prefixes the holder may request
+type tokenClaims struct {
+	Sizes   []string `json:"sizes"`
+	Sources []string `json:"sources"`
+	jwt.RegisteredClaims
+}
+
+func loadTokenKey() error {
+	key, err := current().readTokenKey()
+
+	if err != nil {
+		return err
+	}
+
+	tokenKey = key
+	return nil
+}
+
+// Returns the key tokens are checked with, refusing an empty secret, which
+// would let anyone sign tokens
+func (s settings) readTokenKey() (interface{}, error) {
+	algorithm := s.GetString("jwt.algorithm")
+
+	if algorithm == "HS256" {
+		secret := s.GetString("jwt.secret")
+
+		if secret == "" {
+			return nil, fmt.Errorf("jwt.secret: required for HS256")
+		}
+
+		return []byte(secret), nil
+	}
+
+	if algorithm != "RS256" && algorithm != "ES256" {
+		return nil, fmt.Errorf("jwt.algorithm: unsupported JWT algorithm %q", algorithm)
+	}
+
+	file := s.GetString("jwt.public-key")
+
+	if file == "" {
+		return nil, fmt.Errorf("jwt.public-key: required for %s", algorithm)
+	}
+
+	pem, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return nil, fmt.Errorf("jwt.public-key: %v", err)
+	}
+
+	var key interface{}
+
+	if algorithm == "RS256" {
+		key, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+	} else {
+		key, err = jwt.ParseECPublicKeyFromPEM(pem)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("jwt.public-key: %v", err)
+	}
+
+	return key, nil
+}
+
+func bearerToken(request *http.Request) string {
+	header := request.Header.Get("Authorization")
+
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return header[7:]
+	}
+
+	return ""
+}
+
+func parseToken(raw string) (*client, error) {
+	// Tokens without an expiry would be valid forever
+	options := []jwt.ParserOption{jwt.WithValidMethods([]string{viper.GetString("jwt.algorithm")}), jwt.WithExpirationRequired()}
+
+	if issuer := viper.GetString("jwt.issuer"); issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+
+	if audience := viper.GetString("jwt.audience"); audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (interface{}, error) {
+		return tokenKey, nil
+	}, options...)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid token")
+	}
+
+	return &client{ID: claims.Subject, Sizes: claims.Sizes, Sources: claims.Sources}, nil
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joelchen/gothumb/sign"
+	"github.com/joelchen/gothumb/signing"
+	"github.com/spf13/viper"
+)
+
+// A signing key as /keys shows it, by fingerprint rather than secret
+type keyInfo struct {
+	Fingerprint string     `json:"fingerprint"`
+	Algorithm   string     `json:"algorithm"`
+	Signs       bool       `json:"signs"`
+	Until       *time.Time `json:"until,omitempty"`
+}
+
+// Shows the signing keys on GET, and on POST installs the key given as key,
+// with its algorithm if not the default, in place of the one signing. The
+// replaced key is still accepted for grace, by default server.key-grace.
+// Requests must carry admin.token as a bearer token:
+//
+//	curl -X POST -H 'Authorization: Bearer ...' --data-urlencode key@new.key 'localhost:6060/keys?grace=48h'
+func handleKeys(writer http.ResponseWriter, request *http.Request) {
+	if !authorizeAdmin(writer, request) {
+		return
+	}
+
+	switch request.Method {
+	case "GET":
+	case "POST":
+		key, grace, err := keyChange(request)
+
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		signing.Install(key, grace)
+		logger.Warn("signing key installed", "fingerprint", fingerprint(key.Secret), "algorithm", key.Algorithm, "grace", grace.String(), "client_ip", clientIP(request))
+	default:
+		writer.Header().Set("Allow", "GET, POST")
+		http.Error(writer, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(writer).Encode(keyInfos())
+}
+
+// Reads and checks the key to install and its grace period
+func keyChange(request *http.Request) (signing.Key, time.Duration, error) {
+	if err := request.ParseForm(); err != nil {
+		return signing.Key{}, 0, err
+	}
+
+	key := signing.Key{
+		Secret:    strings.TrimSpace(request.Form.Get("key")),
+		Algorithm: request.Form.Get("algorithm"),
+	}
+
+	if key.Algorithm == "" {
+		key.Algorithm = signing.DefaultAlgorithm()
+	}
+
+	if len(key.Secret) < minKeyLength {
+		return key, 0, fmt.Errorf("key: keys must be at least %d characters", minKeyLength)
+	}
+
+	if _, ok := sign.Algorithms[strings.ToLower(key.Algorithm)]; !ok {
+		return key, 0, fmt.Errorf("algorithm: unknown algorithm %q", key.Algorithm)
+	}
+
+	grace := viper.GetDuration("server.key-grace")
+
+	if value := request.Form.Get("grace"); value != "" {
+		var err error
+
+		if grace, err = time.ParseDuration(value); err != nil || grace < 0 {
+			return key, 0, fmt.Errorf("grace: %q is not a duration such as 30m or 24h", value)
+		}
+	}
+
+	return key, grace, nil
+}
+
+// Lists the keys accepted, the one signing first, with the end of the
+// grace period of those replaced
+func keyInfos() []keyInfo {
+	retired := signing.Retired()
+	keys := signing.Keys()
+	infos := make([]keyInfo, len(keys))
+
+	for i, key := range keys {
+		infos[i] = keyInfo{Fingerprint: fingerprint(key.Secret), Algorithm: key.Algorithm, Signs: i == 0}
+
+		for _, r := range retired {
+			if r.Key == key {
+				until := r.Until
+				infos[i].Until = &until
+			}
+		}
+	}
+
+	return infos
+}
+
+// Identifies a key without revealing it
+func fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
+}
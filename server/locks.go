@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/joelchen/gothumb/internal/requestid"
+	"github.com/joelchen/gothumb/storage"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+)
+
+var (
+	lockStats  = expvar.NewMap("locks")
+	lockClient *redis.Client
+)
+
+// Leases this instance holds, by cache path, with the token that proves
+// ownership when releasing them
+var heldLeases = struct {
+	sync.Mutex
+	tokens map[string]string
+}{tokens: map[string]string{}}
+
+// Deletes a lease only while it still holds our token, so an expired lease
+// taken over by another instance is left alone
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Connects to locks.redis when set, so instances sharing a cache bucket
+// generate each thumbnail once between them
+func setupLocks() error {
+	address := viper.GetString("locks.redis")
+
+	if address == "" {
+		return nil
+	}
+
+	options, err := redis.ParseURL(address)
+
+	if err != nil {
+		return err
+	}
+
+	lockClient = redis.NewClient(options)
+	return nil
+}
+
+func leaseKey(path string) string {
+	return viper.GetString("locks.prefix") + path
+}
+
+// Runs produce while holding the Redis lease on a thumbnail, or waits for
+// the instance holding it to store its result and reads that instead. If
+// the holder gives up without storing one, the lease is taken over. Redis
+// failures are logged and the thumbnail is generated here, as the lease
+// only saves work.
+func leased(ctx context.Context, t *tenant, path, size string, produce func(ctx context.Context) (*result, int, error)) (*result, int, error) {
+	// Without a bucket there is nothing to share between instances
+	if lockClient == nil || storage.Bucket() == "" {
+		return produce(ctx)
+	}
+
+	for {
+		held, err := acquireLease(ctx, path)
+
+		if err != nil {
+			lockStats.Add("errors", 1)
+			logger.Warnf("locks: %v", err)
+			return produce(ctx)
+		}
+
+		if held {
+			result, code, err := produce(ctx)
+
+			// Successful results keep the lease until their upload is done
+			if err != nil {
+				releaseLease(path)
+			}
+
+			return result, code, err
+		}
+
+		lockStats.Add("waited", 1)
+
+		if result, ok := awaitResult(ctx, t, path, size); ok {
+			lockStats.Add("shared", 1)
+			return result, 0, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, 605, ctx.Err()
+		}
+	}
+}
+
+// Takes the lease on a thumbnail for locks.ttl unless another instance
+// holds it
+func acquireLease(ctx context.Context, path string) (bool, error) {
+	token := requestid.New()
+	held, err := lockClient.SetNX(ctx, leaseKey(path), token, viper.GetDuration("locks.ttl")).Result()
+
+	if err != nil || !held {
+		return false, err
+	}
+
+	heldLeases.Lock()
+	heldLeases.tokens[path] = token
+	heldLeases.Unlock()
+
+	lockStats.Add("acquired", 1)
+	return true, nil
+}
+
+// Gives up the lease on a thumbnail if this instance holds it
+func releaseLease(path string) {
+	if lockClient == nil {
+		return
+	}
+
+	heldLeases.Lock()
+	token, ok := heldLeases.tokens[path]
+	delete(heldLeases.tokens, path)
+	heldLeases.Unlock()
+
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := releaseScript.Run(ctx, lockClient, []string{leaseKey(path)}, token).Err(); err != nil {
+		lockStats.Add("errors", 1)
+		logger.Warnf("locks: %v", err)
+	}
+}
+
+// Polls the bucket every locks.poll for the thumbnail another instance is
+// generating, until it is stored or the lease is gone
+func awaitResult(ctx context.Context, t *tenant, path, size string) (*result, bool) {
+	ticker := time.NewTicker(viper.GetDuration("locks.poll"))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-ticker.C:
+		}
+
+		if result, ok := cachedResult(ctx, t, path, size); ok {
+			return result, true
+		}
+
+		exists, err := lockClient.Exists(ctx, leaseKey(path)).Result()
+
+		if err != nil || exists == 0 {
+			// The upload may have landed just before the lease was released
+			return cachedResult(ctx, t, path, size)
+		}
+	}
+}
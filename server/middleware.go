@@ -0,0 +1,86 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Middleware wraps the handler for the stages after it in the chain
+type Middleware func(next http.Handler) http.Handler
+
+// Stages server.middleware can name, in the order they run by default
+var middlewares = struct {
+	sync.RWMutex
+	byName map[string]Middleware
+}{byName: map[string]Middleware{
+	"request-id": withRequestID,
+	"access-log": logAccess,
+	"metrics":    countRequests,
+	"deadline":   withDeadline,
+	"ip-filter":  filterIPs,
+	"cors":       handleCORS,
+	"discovery":  withDiscovery,
+	"batch":      withBatch,
+}}
+
+var defaultMiddleware = []string{"request-id", "access-log", "metrics", "deadline", "ip-filter", "cors", "discovery", "batch"}
+
+// RegisterMiddleware makes a stage available to server.middleware under a
+// name, replacing a built-in one of the same name. It must be called before
+// New.
+func RegisterMiddleware(name string, m Middleware) {
+	middlewares.Lock()
+	middlewares.byName[name] = m
+	middlewares.Unlock()
+}
+
+// Wraps the router in the stages listed in server.middleware, the first
+// running outermost. Signatures and rate limits are checked by the router
+// once the size is known, so they always apply.
+func chain(next http.Handler) (http.Handler, error) {
+	middlewares.RLock()
+	defer middlewares.RUnlock()
+
+	names := viper.GetStringSlice("server.middleware")
+
+	for i := len(names) - 1; i >= 0; i-- {
+		m, ok := middlewares.byName[names[i]]
+
+		if !ok {
+			return nil, fmt.Errorf("Unknown middleware: %s", names[i])
+		}
+
+		next = m(next)
+	}
+
+	return next, nil
+}
+
+// Request counts by status class, published at /debug/vars on the admin
+// listener
+var httpStats = expvar.NewMap("http")
+
+func countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		httpStats.Add("in_flight", 1)
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: writer}
+		next.ServeHTTP(recorder, request)
+		httpStats.Add("in_flight", -1)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		class := strconv.Itoa(recorder.status/100) + "xx"
+		httpStats.Add("requests", 1)
+		httpStats.Add(class, 1)
+		statsdMetric("http.request_ms", strconv.FormatFloat(float64(time.Since(start).Microseconds())/1000, 'f', -1, 64), "ms", "status:"+class)
+	})
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/joelchen/gothumb/source"
+	"github.com/joelchen/gothumb/storage"
+	"github.com/julienschmidt/httprouter"
+)
+
+// The size name under which originals are served, signed and allowed to
+// clients like any other size
+const originalSize = "orig"
+
+// Whether a request asks for an untouched original rather than a thumbnail
+func isOriginal(size string) bool {
+	return current().isOriginal(size)
+}
+
+func (s settings) isOriginal(size string) bool {
+	return size == originalSize && s.GetBool("originals.enabled")
+}
+
+// Streams a source as it is, after the same signature, client, hotlink and
+// rate limit checks as thumbnails, with the caching headers configured for
+// the orig size
+func handleOriginal(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	c, code, err := authorizeRequest(request, params, originalSize)
+
+	if err != nil {
+		auditFailure(request, code, err)
+		httpError(writer, request, err, code)
+		return
+	}
+
+	access := accessInfo(request)
+	access.Size = originalSize
+
+	// There is nothing to downgrade an original to, so foreign sites are
+	// refused whatever hotlink.action says
+	if !refererAllowed(request) {
+		err = fmt.Errorf("Hotlinking not allowed")
+		auditFailure(request, 617, err)
+		httpError(writer, request, err, 617)
+		return
+	}
+
+	if limiter != nil && !limiter.allow(rateLimitKey(request, c)) {
+		httpError(writer, request, errRateLimited, 616)
+		return
+	}
+
+	t := requestTenant(request)
+
+	if code, err := checkQuota(t, c); err != nil {
+		httpError(writer, request, err, code)
+		return
+	}
+
+	billRequest(request, t, c)
+
+	if err = setDownloadHeader(writer, request); err != nil {
+		httpError(writer, request, err, 620)
+		return
+	}
+
+	sourcePath := strings.TrimPrefix(params.ByName("source"), "/")
+	sourceURL, err := url.Parse(sourcePath)
+
+	if err != nil {
+		httpError(writer, request, err, 603)
+		return
+	}
+
+	if err = checkBudget("source-bytes"); err != nil {
+		httpError(writer, request, err, 623)
+		return
+	}
+
+	setSurrogateKeys(writer, params.ByName("source"), originalSize)
+	body, original, code, err := openOriginal(request, sourceURL)
+
+	if err != nil {
+		httpError(writer, request, err, code)
+		return
+	}
+
+	defer body.Close()
+	access.Cache = "pass"
+
+	// Origins do not always say what they serve
+	reader := bufio.NewReader(body)
+
+	if original.ContentType == "" {
+		head, _ := reader.Peek(512)
+		original.ContentType = http.DetectContentType(head)
+	}
+
+	writer.Header().Set("Content-Type", original.ContentType)
+
+	if original.ETag != "" {
+		writer.Header().Set("ETag", `"`+original.ETag+`"`)
+	}
+
+	if !original.LastModified.IsZero() {
+		writer.Header().Set("Last-Modified", original.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	setCacheHeaders(writer, requestTenant(request), originalSize)
+
+	if notModified(request, original) {
+		writeNotModified(writer)
+		return
+	}
+
+	if original.ContentLength >= 0 {
+		writer.Header().Set("Content-Length", strconv.FormatInt(original.ContentLength, 10))
+	}
+
+	if request.Method == "HEAD" {
+		return
+	}
+
+	n, err := io.Copy(writer, reader)
+	spendBudget(n, 0)
+
+	if err != nil {
+		httpError(writer, request, err, 611)
+	}
+}
+
+// Opens a source from the cache bucket when it has no host, or from its
+// URL, describing it in a result without data
+func openOriginal(request *http.Request, sourceURL *url.URL) (io.ReadCloser, *result, int, error) {
+	ctx := request.Context()
+
+	if sourceURL.Host != "" {
+		body, validators, err := source.Fetch(ctx, sourceURL.String(), source.Validators{})
+
+		if err != nil {
+			return nil, nil, 610, err
+		}
+
+		original := &result{ContentLength: -1, Size: originalSize, Source: validators}
+
+		if sized, ok := body.(*source.Sized); ok {
+			original.ContentLength = sized.Length
+		}
+
+		// Weak validators cannot be passed on as strong ones
+		if etag := validators.ETag; !strings.HasPrefix(etag, "W/") {
+			original.ETag = strings.Trim(etag, `"`)
+		}
+
+		if modified, err := time.Parse(http.TimeFormat, validators.LastModified); err == nil {
+			original.LastModified = modified
+		}
+
+		return body, original, 0, nil
+	}
+
+	bucket := requestTenant(request).sourceBucket()
+
+	if bucket == "" {
+		return nil, nil, 603, fmt.Errorf("Source has no host and no bucket is configured")
+	}
+
+	ctx, cancel := source.FetchContext(ctx)
+	output, err := storage.Service().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(sourceURL.Path),
+	}, storage.RequestID(ctx))
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		err = errSourceNotFound
+	}
+
+	if err != nil {
+		cancel()
+		return nil, nil, 608, err
+	}
+
+	return source.CancelOnClose(output.Body, cancel), &result{
+		ContentType:   aws.StringValue(output.ContentType),
+		ContentLength: aws.Int64Value(output.ContentLength),
+		ETag:          strings.Trim(aws.StringValue(output.ETag), `"`),
+		LastModified:  aws.TimeValue(output.LastModified),
+		Size:          originalSize,
+	}, 0, nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+// Siblings queued for generation, with the sources already queued so a
+// burst of requests for one image queues it once
+var prefetches = struct {
+	sync.Mutex
+	queue   chan thumbnail
+	pending map[string]bool
+	limiter *rate.Limiter
+}{pending: map[string]bool{}}
+
+// Starts the prefetch worker when prefetch.siblings is set
+func setupPrefetch() {
+	if len(viper.GetStringMapStringSlice("prefetch.siblings")) == 0 {
+		return
+	}
+
+	prefetches.queue = make(chan thumbnail, viper.GetInt("prefetch.queue"))
+	prefetches.limiter = rate.NewLimiter(rate.Limit(viper.GetFloat64("prefetch.rate")), 1)
+	go prefetchWorker()
+}
+
+// Queues the sizes listed under prefetch.siblings for a freshly generated
+// thumbnail, as pages showing one size tend to ask for the others soon
+// after. Siblings are skipped when the queue is full or prefetch.paused is
+// set, and for thumbnails with options from their URL.
+func prefetchSiblings(thumb thumbnail) {
+	if prefetches.queue == nil || thumb.Watermark || thumb.Options != nil || viper.GetBool("prefetch.paused") {
+		return
+	}
+
+	prefetches.Lock()
+	defer prefetches.Unlock()
+
+	key := prefetchKey(thumb)
+
+	if prefetches.pending[key] || len(viper.GetStringSlice("prefetch.siblings."+thumb.Size)) == 0 {
+		return
+	}
+
+	select {
+	case prefetches.queue <- thumb:
+		prefetches.pending[key] = true
+	default:
+	}
+}
+
+// Generates queued siblings one source at a time, at most prefetch.rate
+// sources per second
+func prefetchWorker() {
+	for thumb := range prefetches.queue {
+		sizes := viper.GetStringSlice("prefetch.siblings." + thumb.Size)
+
+		// Siblings queued before prefetching was paused are dropped
+		if !viper.GetBool("prefetch.paused") {
+			prefetches.limiter.Wait(context.Background())
+
+			if err := generate(context.Background(), thumb.Tenant, thumb.Source, sizes, false, fetchOriginal); err != nil {
+				logger.Warn("prefetch failed", "source", thumb.Source, "sizes", sizes, "error", err)
+			}
+		}
+
+		prefetches.Lock()
+		delete(prefetches.pending, prefetchKey(thumb))
+		prefetches.Unlock()
+	}
+}
+
+// Keeps the same source and size of different tenants apart
+func prefetchKey(thumb thumbnail) string {
+	if thumb.Tenant != nil {
+		return thumb.Tenant.Name + " " + thumb.Source + " " + thumb.Size
+	}
+
+	return thumb.Source + " " + thumb.Size
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+var limiter *rateLimiter
+
+// Token-bucket rate limiter keyed by client ID or IP address
+type rateLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	visitors map[string]*visitor
+}
+
+type visitor struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	l := &rateLimiter{
+		limit:    rate.Limit(perSecond),
+		burst:    burst,
+		visitors: map[string]*visitor{},
+	}
+
+	go l.evict(time.Minute)
+	return l
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	v, ok := l.visitors[key]
+
+	if !ok {
+		v = &visitor{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.visitors[key] = v
+	}
+
+	v.seen = time.Now()
+	return v.limiter.Allow()
+}
+
+// Forgets visitors idle for longer than the interval so the map stays small
+func (l *rateLimiter) evict(interval time.Duration) {
+	for range time.Tick(interval) {
+		l.mu.Lock()
+
+		for key, v := range l.visitors {
+			if time.Since(v.seen) > interval {
+				delete(l.visitors, key)
+			}
+		}
+
+		l.mu.Unlock()
+	}
+}
+
+func rateLimitKey(request *http.Request, c *client) string {
+	if c != nil && c.ID != "" {
+		return "client:" + c.ID
+	}
+
+	return "ip:" + clientIP(request)
+}
+
+func setupRateLimiter() {
+	if perSecond := viper.GetFloat64("rate-limit.requests-per-second"); perSecond > 0 {
+		limiter = newRateLimiter(perSecond, viper.GetInt("rate-limit.burst"))
+	}
+}
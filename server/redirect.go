@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudfront/sign"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/joelchen/gothumb/storage"
+	"github.com/spf13/viper"
+)
+
+// Signs CloudFront redirect URLs when redirect.cloudfront-key-id is set
+var cloudfrontSigner *sign.URLSigner
+
+// Validates redirect.mode and loads the CloudFront signing key
+func setupRedirects() error {
+	switch viper.GetString("redirect.mode") {
+	case "", "s3":
+		return nil
+	case "cloudfront":
+		if viper.GetString("redirect.cloudfront-domain") == "" {
+			return fmt.Errorf("redirect.cloudfront-domain is required")
+		}
+
+		keyID := viper.GetString("redirect.cloudfront-key-id")
+
+		if keyID == "" {
+			return nil
+		}
+
+		key, err := sign.LoadPEMPrivKeyFile(viper.GetString("redirect.cloudfront-private-key"))
+
+		if err != nil {
+			return err
+		}
+
+		cloudfrontSigner = sign.NewURLSigner(keyID, key)
+		return nil
+	default:
+		return fmt.Errorf("Unknown redirect mode: %s", viper.GetString("redirect.mode"))
+	}
+}
+
+// Returns a URL the client can fetch a cached result from directly, valid
+// for redirect.expires
+func storageURL(svc *s3.S3, path string) (string, error) {
+	expires := viper.GetDuration("redirect.expires")
+
+	if viper.GetString("redirect.mode") == "s3" {
+		req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+			Bucket: aws.String(storage.Bucket()),
+			Key:    aws.String(path),
+		})
+
+		return req.Presign(expires)
+	}
+
+	domain := strings.TrimSuffix(viper.GetString("redirect.cloudfront-domain"), "/")
+
+	if !strings.Contains(domain, "://") {
+		domain = "https://" + domain
+	}
+
+	location := domain + "/" + strings.TrimPrefix(path, "/")
+
+	if cloudfrontSigner == nil {
+		return location, nil
+	}
+
+	return cloudfrontSigner.Sign(location, time.Now().Add(expires))
+}
+
+// Redirects to the cached result in storage instead of proxying its bytes,
+// returning false when nothing fresh is cached and the thumbnail has to be
+// generated
+func redirectToCached(writer http.ResponseWriter, request *http.Request, svc *s3.S3, path string) bool {
+	ctx, cancel := stageTimeout(request.Context(), "cache-read")
+	output, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(storage.Bucket()),
+		Key:    aws.String(path),
+	}, storage.RequestID(ctx))
+	cancel()
+
+	if err != nil || isStale(output.LastModified) {
+		return false
+	}
+
+	location, err := storageURL(svc, path)
+
+	if err != nil {
+		httpError(writer, request, err, 619)
+		return true
+	}
+
+	writer.Header().Set("Cache-Control", "private, max-age="+viper.GetString("redirect.max-age"))
+	http.Redirect(writer, request, location, http.StatusFound)
+	return true
+}
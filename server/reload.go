@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/joelchen/gothumb/internal/secrets"
+	"github.com/joelchen/gothumb/processor"
+	"github.com/joelchen/gothumb/signing"
+	"github.com/spf13/viper"
+)
+
+// Held while a reload runs, as the file watcher and SIGHUP may both start
+// one
+var reloading sync.Mutex
+
+// Reloads the config file on SIGHUP, and whenever it changes when
+// reload.watch is set. Settings under reload.exclude keep the values they
+// had at startup. A server.key replaced by a reload or by the secret
+// backends is still accepted for server.key-grace.
+func watchConfig() {
+	for _, key := range viper.GetStringSlice("reload.exclude") {
+		viper.Set(key, viper.Get(key))
+	}
+
+	signing.Refresh(viper.GetDuration("server.key-grace"))
+	secrets.OnChange(func() { signing.Refresh(viper.GetDuration("server.key-grace")) })
+
+	file := viper.ConfigFileUsed()
+
+	if file == "" {
+		return
+	}
+
+	if viper.GetBool("reload.watch") {
+		if err := watchConfigFile(file); err != nil {
+			logger.Errorf("Watching config: %v", err)
+		}
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	go func() {
+		for range hangups {
+			reloadConfig(file)
+		}
+	}()
+}
+
+// Reloads the config whenever the file is written or replaced. Its
+// directory is watched, so editors saving through a new file and
+// Kubernetes swapping a ConfigMap's symlink are noticed too.
+func watchConfigFile(file string) error {
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return err
+	}
+
+	if err = watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	name := filepath.Clean(file)
+	real, _ := filepath.EvalSymlinks(file)
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				current, _ := filepath.EvalSymlinks(file)
+				written := filepath.Clean(event.Name) == name && event.Op&(fsnotify.Write|fsnotify.Create) != 0
+
+				if written || current != "" && current != real {
+					real = current
+					reloadConfig(file)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				logger.Errorf("Watching config: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Reads the changed file into a copy of the config in use, which keeps its
+// defaults, environment and overrides, and validates that copy. Only a file
+// that validates and names no unregistered operation then replaces the
+// file's settings in use, in one swap, so requests never see a config that
+// failed.
+func reloadConfig(file string) {
+	reloading.Lock()
+	defer reloading.Unlock()
+
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		logger.Errorf("Reloading config: %v", err)
+		return
+	}
+
+	candidate := *viper.GetViper()
+
+	if err = candidate.ReadConfig(bytes.NewReader(data)); err != nil {
+		logger.Errorf("Reloading config: %v", err)
+		return
+	}
+
+	if err = (settings{&candidate}).validate(); err == nil {
+		err = processor.CheckOperations(candidate.GetStringSlice("operations"))
+	}
+
+	if err != nil {
+		logger.Errorf("Reloading config, keeping the previous one: %v", err)
+		return
+	}
+
+	if err = viper.ReadConfig(bytes.NewReader(data)); err != nil {
+		logger.Errorf("Reloading config: %v", err)
+		return
+	}
+
+	applyConfig()
+}
+
+// Rebuilds what is derived from the config once rather than read on each
+// request. Sizes, quality and caching headers need nothing, as they are
+// looked up as requests come in.
+func applyConfig() {
+	if err := setupAccessLists(); err != nil {
+		logger.Errorf("Reloading config: %v", err)
+		return
+	}
+
+	fallbacks.Lock()
+	fallbacks.images = map[string][]byte{}
+	fallbacks.Unlock()
+
+	signing.Refresh(viper.GetDuration("server.key-grace"))
+
+	logger.Infof("Config reloaded")
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/joelchen/gothumb/internal/reporting"
+)
+
+// ErrorReporter receives errors worth a look, with tags saying what kind
+// they are ("panic", "process", "storage" or "budget") and describing the request,
+// such as its request_id, source and size
+type ErrorReporter func(ctx context.Context, err error, tags map[string]string)
+
+// RegisterErrorReporter sends errors to the reporter as well as to Sentry
+// when sentry.dsn is set. It must be called before New.
+func RegisterErrorReporter(r ErrorReporter) {
+	reporting.Register(reporting.Reporter(r))
+}
+
+// Reports panics in handlers before passing them on to net/http, which
+// logs them and drops the connection. Aborted responses are not reported.
+func reportPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v != http.ErrAbortHandler {
+					reporting.Report(request.Context(), fmt.Errorf("panic: %v", v), "panic")
+				}
+
+				panic(v)
+			}
+		}()
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// Reports an S3 error other than a missing key or a request given up on
+func reportStorageError(ctx context.Context, err error) {
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound", "InvalidRange", request.CanceledErrorCode:
+			return
+		}
+	}
+
+	reporting.Report(ctx, err, "storage")
+}
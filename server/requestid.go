@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/joelchen/gothumb/internal/requestid"
+)
+
+// Takes the X-Request-ID sent by the client or a proxy, generating one when
+// missing, and echoes it in the response
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id := request.Header.Get("X-Request-ID")
+
+		if id == "" || len(id) > 128 {
+			id = requestid.New()
+		}
+
+		writer.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(writer, request.WithContext(requestid.With(request.Context(), id)))
+	})
+}
+
+func requestID(ctx context.Context) string {
+	return requestid.From(ctx)
+}
+
+// Keeps the request ID, log fields, stage timings and usage accounts of a
+// request for work that outlives it
+func detachContext(ctx context.Context) context.Context {
+	return keepUsage(keepTimings(logging.With(requestid.Detach(ctx), logging.Fields(ctx)...), ctx), ctx)
+}
@@ -0,0 +1,934 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/joelchen/gothumb/internal/reporting"
+	"github.com/joelchen/gothumb/processor"
+	"github.com/joelchen/gothumb/signing"
+	"github.com/joelchen/gothumb/source"
+	"github.com/joelchen/gothumb/storage"
+	"github.com/julienschmidt/httprouter"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+func newRouter() *httprouter.Router {
+	router := httprouter.New()
+
+	route := "/:size/*source"
+	handle := withDefaultSize(handleResize)
+
+	switch {
+	case urlOptionsMode():
+		route = "/:signature/*path"
+		handle = withURLOptions(handleResize)
+	case signing.InPath():
+		route = "/:signature/:size/*source"
+	}
+
+	router.GET(route, handle)
+	router.HEAD(route, handle)
+
+	// Paths too short for the route can still be sources at default-size
+	router.NotFound = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != "GET" && request.Method != "HEAD" {
+			http.NotFound(writer, request)
+			return
+		}
+
+		withDefaultSize(handleResize)(writer, request, nil)
+	})
+
+	return router
+}
+
+// Passes the handler the params of a thumbnail path as thumbnailParams reads
+// them, or responds 404
+func withDefaultSize(handle httprouter.Handle) httprouter.Handle {
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		params, ok := thumbnailParams(requestTenant(request), request.URL.Path, params)
+
+		if !ok {
+			http.NotFound(writer, request)
+			return
+		}
+
+		handle(writer, request, params)
+	}
+}
+
+func handleResize(w http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	request = withTimings(request)
+	defer finishTimings(request.Context())
+
+	request = withUsage(request)
+	served := &statusRecorder{ResponseWriter: w}
+	defer func() { billResponse(request.Context(), served.bytes) }()
+
+	writer := bufferResponse(served)
+	writer.onCommit = func(header http.Header) {
+		setServerTiming(request.Context(), header)
+		setDebugHeaders(request, header)
+	}
+	defer writer.commit()
+
+	t := requestTenant(request)
+	size := resolveSize(t, params.ByName("size"))
+
+	if isOriginal(size) {
+		handleOriginal(writer, request, params)
+		return
+	}
+
+	width, height, err := parseWidthAndHeight(t, size)
+
+	if err != nil {
+		httpError(writer, request, err, 601)
+		return
+	}
+
+	start := time.Now()
+	c, code, err := authorizeRequest(request, params, size)
+	timeStage(request.Context(), "auth", start)
+
+	if err != nil {
+		auditFailure(request, code, err)
+		httpError(writer, request, err, code)
+		return
+	}
+
+	thumb := thumbnail{Tenant: t, Source: strings.TrimPrefix(params.ByName("source"), "/"), Size: size, Width: width, Height: height, Options: requestURLOptions(request)}
+	request = request.WithContext(logging.With(request.Context(), "source", thumb.Source, "size", size))
+	access := accessInfo(request)
+	access.Size = size
+
+	if !refererAllowed(request) {
+		if thumb, err = hotlinkThumbnail(thumb); err != nil {
+			auditFailure(request, 617, err)
+			httpError(writer, request, err, 617)
+			return
+		}
+	}
+
+	// Sizes with a format of their own are not negotiated, nor are URLs
+	// asking for one
+	switch {
+	case thumb.Watermark || sizeOption(t, size, "format") != nil:
+	case thumb.Options != nil && thumb.Options.Format != "":
+		thumb.Format = thumb.Options.Format
+	default:
+		thumb.Format = negotiateFormat(request)
+	}
+
+	if limiter != nil && !limiter.allow(rateLimitKey(request, c)) {
+		httpError(writer, request, errRateLimited, 616)
+		return
+	}
+
+	if code, err := checkQuota(t, c); err != nil {
+		httpError(writer, request, err, code)
+		return
+	}
+
+	billRequest(request, t, c)
+
+	if err = setDownloadHeader(writer, request); err != nil {
+		httpError(writer, request, err, 620)
+		return
+	}
+
+	sourceURL, err := url.Parse(strings.TrimPrefix(params.ByName("source"), "/"))
+
+	if err != nil {
+		httpError(writer, request, err, 603)
+		return
+	}
+
+	resultPath := t.cachePath(sourceURL, thumb.variant())
+	setSurrogateKeys(writer, params.ByName("source"), thumb.Size)
+
+	if result, ok := hotResults.get(resultPath); ok {
+		access.Cache = "hot"
+
+		if err = writeThumbnail(writer, request, result); err != nil {
+			httpError(writer, request, err, 611)
+		}
+
+		return
+	}
+
+	if storage.Bucket() == "" {
+		result, code, e := coalesce(request, resultPath, thumb.Size, func(ctx context.Context) (*result, int, error) {
+			start := time.Now()
+			body, _, err := source.Fetch(ctx, sourceURL.String(), source.Validators{})
+			timeStage(ctx, "fetch", start)
+
+			if err != nil {
+				return nil, 604, err
+			}
+
+			result, err := renderThumbnail(ctx, body, resultPath, thumb, source.Validators{})
+			return result, 605, err
+		})
+
+		if e != nil {
+			thumbnailError(writer, request, thumb.Size, e, code)
+			return
+		}
+
+		if e = writeThumbnail(writer, request, result); e != nil {
+			httpError(writer, request, e, 611)
+		}
+
+		return
+	}
+
+	svc := storage.Service()
+
+	if request.Method == "HEAD" && serveCachedHead(writer, request, svc, resultPath, thumb.Size) {
+		access.Cache = "hit"
+		return
+	}
+
+	// Storage URLs cannot carry a Content-Disposition, so downloads are proxied
+	if request.Method == "GET" && viper.GetString("redirect.mode") != "" && request.URL.Query().Get("download") == "" && redirectToCached(writer, request, svc, resultPath) {
+		access.Cache = "hit"
+		return
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(storage.Bucket()),
+		Key:    aws.String(resultPath),
+	}
+
+	// Ranges are passed through to S3 for cached results; If-Range is not
+	// supported, so such requests get the whole object
+	if rangeHeader := request.Header.Get("Range"); rangeHeader != "" && request.Header.Get("If-Range") == "" {
+		input.Range = aws.String(rangeHeader)
+	}
+
+	start = time.Now()
+	readCtx, stop := stageDeadline(request.Context(), "cache-read")
+	output, err := svc.GetObjectWithContext(readCtx, input, storage.RequestID(readCtx))
+	stop()
+	timeStage(request.Context(), "cache-read", start)
+	reportStorageError(request.Context(), err)
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidRange" {
+		writer.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	// Stale thumbnails are served as they are once a budget is spent
+	if err == nil && isStale(output.LastModified) && budgetExhausted().IsZero() {
+		origin, e := url.Parse(strings.TrimPrefix(params.ByName("source"), "/"))
+
+		if e == nil && origin.Host != "" {
+			start := time.Now()
+			body, fresh, e := source.Fetch(request.Context(), origin.String(), source.Validators{
+				ETag:         metadataValue(output.Metadata, "source-etag"),
+				LastModified: metadataValue(output.Metadata, "source-last-modified"),
+			})
+			timeStage(request.Context(), "fetch", start)
+
+			switch {
+			case e == source.ErrNotModified:
+				inBackground(func() {
+					touchResult(detachContext(request.Context()), svc, resultPath, output)
+				})
+			case e != nil:
+				logger.Ctx(request.Context()).Warn("revalidating source failed", "error", e)
+			default:
+				output.Body.Close()
+
+				access.Cache = "miss"
+				result, e := renderThumbnail(request.Context(), body, resultPath, thumb, fresh)
+
+				if e != nil {
+					thumbnailError(writer, request, thumb.Size, e, 605)
+					return
+				}
+
+				if e = writeThumbnail(writer, request, result); e != nil {
+					httpError(writer, request, e, 611)
+				}
+
+				return
+			}
+		}
+	}
+
+	if err != nil {
+		sourceURL, err := url.Parse(strings.TrimPrefix(params.ByName("source"), "/"))
+
+		if err != nil {
+			httpError(writer, request, err, 607)
+			return
+		}
+
+		result, code, err := coalesce(request, resultPath, thumb.Size, func(ctx context.Context) (*result, int, error) {
+			if sourceURL.Host == "" {
+				input := &s3.GetObjectInput{
+					Bucket: aws.String(t.sourceBucket()),
+					Key:    aws.String(params.ByName("source")),
+				}
+
+				start := time.Now()
+				fetchCtx, cancel := source.FetchContext(ctx)
+				output, err := svc.GetObjectWithContext(fetchCtx, input, storage.RequestID(ctx))
+				timeStage(ctx, "fetch", start)
+				reportStorageError(ctx, err)
+
+				if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+					err = errSourceNotFound
+				}
+
+				if err != nil {
+					cancel()
+					return nil, 608, err
+				}
+
+				body := &source.Sized{ReadCloser: source.CancelOnClose(output.Body, cancel), Length: aws.Int64Value(output.ContentLength)}
+				result, err := renderThumbnail(ctx, body, resultPath, thumb, source.Validators{})
+				return result, 609, err
+			}
+
+			start := time.Now()
+			body, fresh, err := source.Fetch(ctx, sourceURL.String(), source.Validators{})
+			timeStage(ctx, "fetch", start)
+
+			if err != nil {
+				return nil, 610, err
+			}
+
+			result, err := renderThumbnail(ctx, body, resultPath, thumb, fresh)
+			return result, 605, err
+		})
+
+		if err != nil {
+			thumbnailError(writer, request, thumb.Size, err, code)
+			return
+		}
+
+		if err = writeThumbnail(writer, request, result); err != nil {
+			httpError(writer, request, err, 611)
+		}
+
+		prefetchSiblings(thumb)
+		return
+	}
+
+	defer output.Body.Close()
+	access.Cache = "hit"
+
+	result := &result{
+		ContentType:   *output.ContentType,
+		ContentLength: *output.ContentLength,
+		ETag:          strings.Trim(aws.StringValue(output.ETag), `"`),
+		LastModified:  cachedLastModified(output.Metadata, output.LastModified),
+		Path:          resultPath,
+		Size:          thumb.Size,
+		Tenant:        thumb.Tenant,
+	}
+
+	// Whole thumbnails small enough are kept in memory for the next request
+	if output.ContentRange == nil && request.Method == "GET" && hotResults.fits(result.ContentLength) {
+		if result.Data, err = ioutil.ReadAll(output.Body); err != nil {
+			httpError(writer, request, err, 611)
+			return
+		}
+
+		hotResults.add(result)
+
+		if err = writeThumbnail(writer, request, result); err != nil {
+			httpError(writer, request, err, 611)
+		}
+
+		return
+	}
+
+	setResultHeaders(writer, result)
+	writer.Header().Set("Accept-Ranges", "bytes")
+
+	if notModified(request, result) {
+		writeNotModified(writer)
+		return
+	}
+
+	if output.ContentRange != nil {
+		writer.Header().Set("Content-Range", *output.ContentRange)
+		writer.WriteHeader(http.StatusPartialContent)
+	}
+
+	if _, err := io.Copy(writer, output.Body); err != nil {
+		httpError(writer, request, err, 611)
+		return
+	}
+}
+
+// Returns the key a thumbnail variant of the source is cached under, which
+// leaves out the source's scheme and host
+func cachePath(source *url.URL, variant string) string {
+	key := *source
+	key.Scheme = ""
+	key.Host = ""
+	dir, file := path.Split(key.String())
+
+	return strings.Join([]string{"cache/", dir, variant, "/", file}, "")
+}
+
+// Parameters of a requested thumbnail
+type thumbnail struct {
+	// Tenant the request was for, nil for the top-level settings
+	Tenant    *tenant
+	Source    string
+	Size      string
+	Width     int
+	Height    int
+	Watermark bool
+	// Negotiated output format, if any
+	Format string
+	// Options from the URL, for imgproxy and thumbor URLs
+	Options *urlOptions
+}
+
+// Returns the cache directory name for the thumbnail, keeping watermarked
+// copies, other formats and those with options from the URL apart from the
+// plain one
+func (t thumbnail) variant() string {
+	variant := t.Size
+
+	switch {
+	case t.Watermark:
+		variant += "-watermarked"
+	case t.Format != "":
+		variant += "-" + t.Format
+	}
+
+	if t.Options != nil {
+		variant += "-" + t.Options.variant()
+	}
+
+	return variant
+}
+
+// Returns the processing options for a thumbnail from the vips section,
+// overridden by those of its size and then those from its URL
+func thumbnailOptions(thumb thumbnail) processor.Options {
+	options := processor.Options{
+		Width:   thumb.Width,
+		Height:  thumb.Height,
+		Crop:    viper.GetBool("vips.crop"),
+		Gravity: viper.GetString("vips.gravity"),
+		Enlarge: viper.GetBool("vips.enlarge"),
+		Quality: viper.GetInt("vips.quality"),
+		Format:  thumb.Format,
+	}
+
+	if quality := cast.ToInt(sizeOption(thumb.Tenant, thumb.Size, "quality")); quality > 0 {
+		options.Quality = quality
+	}
+
+	switch sizeOption(thumb.Tenant, thumb.Size, "fit") {
+	case "cover":
+		options.Crop = true
+	case "contain":
+		options.Crop = false
+	}
+
+	if gravity := cast.ToString(sizeOption(thumb.Tenant, thumb.Size, "crop")); gravity != "" {
+		options.Gravity = gravity
+	}
+
+	if o := thumb.Options; o != nil {
+		options.Crop, options.Gravity, options.Enlarge = o.Crop, o.Gravity, o.Enlarge
+
+		if o.Quality > 0 {
+			options.Quality = o.Quality
+		}
+	}
+
+	if format := cast.ToString(sizeOption(thumb.Tenant, thumb.Size, "format")); format != "" {
+		options.Format = format
+	}
+
+	return options
+}
+
+// Whether the thumbnail is watermarked, for hotlinking or because its size
+// always is
+func (t thumbnail) watermarked() bool {
+	return t.Watermark || cast.ToBool(sizeOption(t.Tenant, t.Size, "watermark"))
+}
+
+// Whether the thumbnail is drawn on with the standard library once resized
+func (t thumbnail) drawn() bool {
+	return t.watermarked() || t.Options != nil && t.Options.Grayscale
+}
+
+// Picks the first format in formats.negotiate that the client accepts and
+// the processor can encode, or none to keep the source's format
+func negotiateFormat(request *http.Request) string {
+	accept := request.Header.Get("Accept")
+
+	for _, format := range viper.GetStringSlice("formats.negotiate") {
+		if strings.Contains(accept, "image/"+format) && containsString(processor.Default.Formats(), format) {
+			return format
+		}
+	}
+
+	return ""
+}
+
+// Answers a HEAD request from the cached result's metadata alone, returning
+// false when nothing is cached and the thumbnail has to be generated
+func serveCachedHead(writer http.ResponseWriter, request *http.Request, svc *s3.S3, path, size string) bool {
+	ctx, cancel := stageTimeout(request.Context(), "cache-read")
+	output, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(storage.Bucket()),
+		Key:    aws.String(path),
+	}, storage.RequestID(ctx))
+	cancel()
+
+	if err != nil {
+		return false
+	}
+
+	result := &result{
+		ContentType:   aws.StringValue(output.ContentType),
+		ContentLength: aws.Int64Value(output.ContentLength),
+		ETag:          strings.Trim(aws.StringValue(output.ETag), `"`),
+		LastModified:  cachedLastModified(output.Metadata, output.LastModified),
+		Path:          path,
+		Size:          size,
+	}
+
+	setResultHeaders(writer, result)
+	writer.Header().Set("Accept-Ranges", "bytes")
+
+	if notModified(request, result) {
+		writeNotModified(writer)
+	}
+
+	return true
+}
+
+type result struct {
+	Data          []byte
+	ContentType   string
+	ContentLength int64
+	ETag          string
+	LastModified  time.Time
+	Path          string
+	Size          string
+	Tenant        *tenant
+	Source        source.Validators
+}
+
+func computeHexMD5(data []byte) string {
+	h := md5.New()
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// Resizes the source and stores the result in the background
+func renderThumbnail(ctx context.Context, body io.ReadCloser, path string, thumb thumbnail, validators source.Validators) (*result, error) {
+	results, err := renderThumbnails(ctx, body, []string{path}, []thumbnail{thumb}, validators)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results[0], nil
+}
+
+// Resizes the source to several thumbnails, decoding it once, and stores
+// them in the background
+func renderThumbnails(ctx context.Context, body io.ReadCloser, paths []string, thumbs []thumbnail, validators source.Validators) ([]*result, error) {
+	results, err := processThumbnails(ctx, body, paths, thumbs, validators)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		hotResults.add(result)
+
+		if storage.Bucket() != "" {
+			storeResult(ctx, result)
+		}
+	}
+
+	return results, nil
+}
+
+// Resizes the source to several thumbnails and reports each to webhooks
+func processThumbnails(ctx context.Context, body io.ReadCloser, paths []string, thumbs []thumbnail, validators source.Validators) ([]*result, error) {
+	start := time.Now()
+	results, err := processImages(ctx, body, paths, thumbs, validators)
+
+	for i, thumb := range thumbs {
+		var result *result
+
+		if err == nil {
+			result = results[i]
+		}
+
+		notifyGeneration(ctx, thumb, paths[i], start, result, err)
+	}
+
+	return results, err
+}
+
+func processImage(ctx context.Context, body io.ReadCloser, path string, thumb thumbnail, validators source.Validators) (*result, error) {
+	results, err := processImages(ctx, body, []string{path}, []thumbnail{thumb}, validators)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results[0], nil
+}
+
+// Resizes the source to the thumbnail at each path in one job, holding a
+// resize slot for every size involved, and fails if any of them does
+func processImages(ctx context.Context, body io.ReadCloser, paths []string, thumbs []thumbnail, validators source.Validators) ([]*result, error) {
+	start := time.Now()
+	img, done, err := source.Read(ctx, body)
+	timeStage(ctx, "fetch", start)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+	spendBudget(int64(len(img)), 0)
+
+	var releases []func()
+	acquired := map[string]bool{}
+
+	releaseAll := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+
+	for _, thumb := range thumbs {
+		if acquired[thumb.Size] {
+			continue
+		}
+
+		release, err := processor.Acquire(ctx, thumb.Size)
+
+		if err != nil {
+			releaseAll()
+			return nil, err
+		}
+
+		releases = append(releases, release)
+		acquired[thumb.Size] = true
+	}
+
+	options := make([]processor.Options, len(thumbs))
+
+	for i, thumb := range thumbs {
+		options[i] = thumbnailOptions(thumb)
+	}
+
+	start = time.Now()
+	outputs, err := processor.ResizeAll(ctx, img, options)
+
+	if err == nil {
+		recordResize(ctx, time.Since(start), outputs)
+	}
+
+	// Watermarks and grayscale are drawn with the standard library, which
+	// only encodes JPEG and PNG
+	for i, thumb := range thumbs {
+		if err != nil || outputs[i].Err != nil || !thumb.drawn() || outputs[i].ContentType == "image/jpeg" || outputs[i].ContentType == "image/png" {
+			continue
+		}
+
+		options[i].Format = "jpeg"
+		start := time.Now()
+		outputs[i].Data, outputs[i].ContentType, outputs[i].Err = processor.Resize(ctx, img, options[i])
+		timeStage(ctx, "resize", start)
+	}
+
+	releaseAll()
+
+	if err != nil {
+		processor.Stats.Add("errors", 1)
+		return nil, err
+	}
+
+	results := make([]*result, len(thumbs))
+
+	for i, output := range outputs {
+		if output.Err != nil {
+			processor.Stats.Add("errors", 1)
+			reporting.Report(ctx, output.Err, "process")
+			return nil, output.Err
+		}
+
+		if results[i], err = finishImage(int64(len(img)), output, paths[i], thumbs[i], validators); err != nil {
+			return nil, err
+		}
+	}
+
+	billPixels(ctx, results, thumbs)
+	return results, nil
+}
+
+// Splits the time a resize job took into waiting for a worker, decoding the
+// source once and resizing each thumbnail, counting the latter two against
+// the budgets
+func recordResize(ctx context.Context, elapsed time.Duration, outputs []processor.Output) {
+	var decode, resize time.Duration
+
+	for _, output := range outputs {
+		decode = output.Decode
+		resize += output.Resize
+	}
+
+	if wait := elapsed - decode - resize; wait > 0 {
+		recordStage(ctx, "queue", wait)
+	}
+
+	if decode > 0 {
+		recordStage(ctx, "decode", decode)
+	}
+
+	recordStage(ctx, "resize", resize)
+	spendBudget(0, (decode + resize).Seconds())
+}
+
+// Watermarks a resized image, turns it gray when its URL asks, runs the
+// configured operations and wraps it in a result
+func finishImage(bytesIn int64, output processor.Output, path string, thumb thumbnail, validators source.Validators) (*result, error) {
+	buf, contentType := output.Data, output.ContentType
+	var err error
+
+	processor.Stats.Add("resizes", 1)
+	processor.Stats.Add("bytes_in", bytesIn)
+	processor.Stats.Add("bytes_out", int64(len(buf)))
+
+	if thumb.watermarked() {
+		if buf, contentType, err = applyWatermark(buf, contentType); err != nil {
+			return nil, err
+		}
+	}
+
+	if thumb.Options != nil && thumb.Options.Grayscale {
+		if buf, contentType, err = applyGrayscale(buf, contentType, thumbnailOptions(thumb).Quality); err != nil {
+			return nil, err
+		}
+	}
+
+	if buf, contentType, err = processor.Apply(buf, contentType); err != nil {
+		return nil, err
+	}
+
+	result := &result{
+		ContentType:   contentType,
+		ContentLength: int64(len(buf)),
+		Data:          buf,
+		ETag:          computeHexMD5(buf),
+		LastModified:  sourceLastModified(validators),
+		Path:          path,
+		Size:          thumb.Size,
+		Tenant:        thumb.Tenant,
+		Source:        validators,
+	}
+
+	return result, nil
+}
+
+// Writes a generated thumbnail, or 304 when the client's copy matches
+func writeThumbnail(writer http.ResponseWriter, request *http.Request, result *result) error {
+	setResultHeaders(writer, result)
+
+	if notModified(request, result) {
+		writeNotModified(writer)
+		return nil
+	}
+
+	_, err := writer.Write(result.Data)
+	return err
+}
+
+func isStale(written *time.Time) bool {
+	maxAge := viper.GetDuration("source.revalidate-after")
+
+	if maxAge <= 0 || written == nil {
+		return false
+	}
+
+	return time.Since(*written) > maxAge
+}
+
+func metadataValue(metadata map[string]*string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return aws.StringValue(v)
+		}
+	}
+
+	return ""
+}
+
+// Returns when the source was last modified, falling back to now for
+// sources that don't say
+func sourceLastModified(validators source.Validators) time.Time {
+	if modified, err := http.ParseTime(validators.LastModified); err == nil {
+		return modified
+	}
+
+	return time.Now().UTC()
+}
+
+// Returns the Last-Modified time stored with a cached result, falling back
+// to when the object was written for results cached before it was recorded
+func cachedLastModified(metadata map[string]*string, written *time.Time) time.Time {
+	if modified, err := http.ParseTime(metadataValue(metadata, "last-modified")); err == nil {
+		return modified
+	}
+
+	return aws.TimeValue(written)
+}
+
+func resultMetadata(result *result) map[string]*string {
+	validators := result.Source
+	metadata := map[string]*string{
+		"last-modified": aws.String(result.LastModified.UTC().Format(http.TimeFormat)),
+	}
+
+	if validators.ETag != "" {
+		metadata["source-etag"] = aws.String(validators.ETag)
+	}
+
+	if validators.LastModified != "" {
+		metadata["source-last-modified"] = aws.String(validators.LastModified)
+	}
+
+	return metadata
+}
+
+// Maps an alias from size-aliases onto the size it stands for, and a
+// literal WxH size onto its configured name in thumbor and imgproxy modes,
+// so their clients can address presets the way they always have
+func resolveSize(t *tenant, str string) string {
+	return current().resolveSize(t, str)
+}
+
+func (s settings) resolveSize(t *tenant, str string) string {
+	if target, ok := cast.ToStringMapString(s.Get("size-aliases"))[str]; ok {
+		str = target
+	}
+
+	if !signing.ThumborModeFrom(s.Viper) && !signing.ImgproxyModeFrom(s.Viper) {
+		return str
+	}
+
+	if _, ok := s.sizeDimensions(t, str); ok {
+		return str
+	}
+
+	for _, name := range s.sizeNames(t) {
+		if value, _ := s.sizeDimensions(t, name); value == str {
+			return name
+		}
+	}
+
+	return str
+}
+
+func parseWidthAndHeight(t *tenant, str string) (width, height int, err error) {
+	return current().parseWidthAndHeight(t, str)
+}
+
+func (s settings) parseWidthAndHeight(t *tenant, str string) (width, height int, err error) {
+	if value, ok := s.sizeDimensions(t, str); ok {
+		sizeParts := strings.Split(value, "x")
+
+		if len(sizeParts) != 2 {
+			return 0, 0, fmt.Errorf("Invalid size requested")
+		}
+
+		width, err = strconv.Atoi(sizeParts[0])
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		height, err = strconv.Atoi(sizeParts[1])
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		return width, height, nil
+	}
+
+	if width, height, ok := s.dynamicSize(str); ok {
+		return width, height, nil
+	}
+
+	err = fmt.Errorf("Invalid size requested")
+	return
+}
+
+func putResult(ctx context.Context, result *result) error {
+	params := &s3.PutObjectInput{
+		Bucket:        aws.String(storage.Bucket()),
+		Key:           aws.String(result.Path),
+		Body:          bytes.NewReader(result.Data),
+		ContentLength: aws.Int64(result.ContentLength),
+		ContentType:   aws.String(result.ContentType),
+		Metadata:      resultMetadata(result),
+		StorageClass:  aws.String(s3.StorageClassReducedRedundancy),
+	}
+
+	start := time.Now()
+	writeCtx, cancel := stageTimeout(ctx, "cache-write")
+	_, err := storage.Service().PutObjectWithContext(writeCtx, params, storage.RequestID(ctx))
+	cancel()
+	timeStage(ctx, "cache-write", start)
+	return err
+}
+
+// Copies a revalidated result onto itself to reset its age
+func touchResult(ctx context.Context, svc *s3.S3, path string, output *s3.GetObjectOutput) {
+	params := &s3.CopyObjectInput{
+		Bucket:            aws.String(storage.Bucket()),
+		Key:               aws.String(path),
+		CopySource:        aws.String(storage.Bucket() + "/" + (&url.URL{Path: path}).EscapedPath()),
+		ContentType:       output.ContentType,
+		Metadata:          output.Metadata,
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+		StorageClass:      aws.String(s3.StorageClassReducedRedundancy),
+	}
+
+	ctx, cancel := stageTimeout(ctx, "cache-write")
+	defer cancel()
+
+	if _, err := svc.CopyObjectWithContext(ctx, params, storage.RequestID(ctx)); err != nil {
+		logger.Ctx(ctx).Error("refreshing cached thumbnail failed", "path", path, "error", err)
+	}
+}
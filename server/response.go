@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/spf13/viper"
+)
+
+// Holds a response in memory until the handler is done, so an error found
+// partway through replaces it with a clean error instead of being appended
+// to an image. Bodies larger than server.response-buffer are streamed once
+// they outgrow it, committing the response.
+type bufferedResponse struct {
+	writer    http.ResponseWriter
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+	limit     int
+	committed bool
+	// Called with the headers just before they are sent
+	onCommit func(http.Header)
+}
+
+func bufferResponse(writer http.ResponseWriter) *bufferedResponse {
+	return &bufferedResponse{
+		writer: writer,
+		header: http.Header{},
+		limit:  int(viper.GetSizeInBytes("server.response-buffer")),
+	}
+}
+
+func (r *bufferedResponse) Header() http.Header {
+	if r.committed {
+		return r.writer.Header()
+	}
+
+	return r.header
+}
+
+func (r *bufferedResponse) WriteHeader(status int) {
+	if r.committed {
+		r.writer.WriteHeader(status)
+		return
+	}
+
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *bufferedResponse) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	if !r.committed && r.body.Len()+len(data) > r.limit {
+		if err := r.commit(); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.committed {
+		return r.writer.Write(data)
+	}
+
+	return r.body.Write(data)
+}
+
+// Sends the status, headers and body buffered so far
+func (r *bufferedResponse) commit() error {
+	if r.committed {
+		return nil
+	}
+
+	r.committed = true
+
+	if r.onCommit != nil {
+		r.onCommit(r.header)
+	}
+
+	for key, values := range r.header {
+		r.writer.Header()[key] = values
+	}
+
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	r.writer.WriteHeader(r.status)
+	_, err := r.writer.Write(r.body.Bytes())
+	r.body.Reset()
+	return err
+}
+
+// Throws away what was buffered so a different response can be written,
+// returning false when part of it was already sent
+func (r *bufferedResponse) reset() bool {
+	if r.committed {
+		return false
+	}
+
+	r.header = http.Header{}
+	r.status = 0
+	r.body.Reset()
+	return true
+}
+
+// Clears a buffered response so an error can take its place. Once part of
+// it has been sent the connection is aborted instead, leaving the client a
+// truncated reply it can detect rather than an image followed by an error.
+func discardResponse(writer http.ResponseWriter, request *http.Request, err error) {
+	if response, ok := writer.(*bufferedResponse); ok && !response.reset() {
+		logger.Ctx(request.Context()).Error("response aborted", "path", request.URL.EscapedPath(), "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestBufferedResponseCommit(t *testing.T) {
+	testConfig(t, map[string]interface{}{"server.response-buffer": "16"})
+	recorder := httptest.NewRecorder()
+	response := bufferResponse(recorder)
+	response.Header().Set("Content-Type", "image/jpeg")
+	response.WriteHeader(http.StatusCreated)
+
+	if _, err := response.Write([]byte("small")); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Body.Len() > 0 || recorder.Header().Get("Content-Type") != "" {
+		t.Fatal("response under server.response-buffer sent before commit")
+	}
+
+	if err := response.commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Code != http.StatusCreated || recorder.Body.String() != "small" || recorder.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("committed %d %q %v", recorder.Code, recorder.Body, recorder.Header())
+	}
+}
+
+func TestBufferedResponseReset(t *testing.T) {
+	testConfig(t, map[string]interface{}{"server.response-buffer": "16"})
+	recorder := httptest.NewRecorder()
+	response := bufferResponse(recorder)
+	response.Header().Set("ETag", `"abc"`)
+	response.Write([]byte("partial"))
+
+	if !response.reset() {
+		t.Fatal("reset refused before anything was sent")
+	}
+
+	if response.Header().Get("ETag") != "" {
+		t.Error("reset kept the buffered headers")
+	}
+
+	response.Write([]byte("error"))
+	response.commit()
+
+	if recorder.Body.String() != "error" || recorder.Header().Get("ETag") != "" {
+		t.Errorf("sent %q with %v after reset", recorder.Body, recorder.Header())
+	}
+
+	// Bodies outgrowing the buffer are streamed, so can no longer be reset
+	response = bufferResponse(httptest.NewRecorder())
+	response.Write([]byte(strings.Repeat("x", 32)))
+
+	if response.reset() {
+		t.Error("reset allowed after the response was streamed")
+	}
+}
+
+func TestErrorHeadersSurviveReset(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   int
+		status int
+		header string
+	}{
+		{"rate limited", errRateLimited, 616, http.StatusTooManyRequests, "1"},
+		{"busy", errBusy, 605, http.StatusServiceUnavailable, "1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testConfig(t, map[string]interface{}{"server.response-buffer": "1KB"})
+			recorder := httptest.NewRecorder()
+			response := bufferResponse(recorder)
+			response.Header().Set("Content-Type", "image/jpeg")
+			request := httptest.NewRequest("GET", "/small/cat.jpg", nil)
+
+			httpError(response, request, test.err, test.code)
+			response.commit()
+
+			if recorder.Code != test.status {
+				t.Errorf("status %d, want %d", recorder.Code, test.status)
+			}
+
+			if got := recorder.Header().Get("Retry-After"); got != test.header {
+				t.Errorf("Retry-After %q, want %q", got, test.header)
+			}
+
+			if got := recorder.Header().Get("Content-Type"); got == "image/jpeg" {
+				t.Error("error sent with the discarded response's content type")
+			}
+		})
+	}
+
+	viper.Reset()
+}
@@ -0,0 +1,29 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package server
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Listens with SO_REUSEPORT so a new process can bind the port before the
+// old one stops accepting
+func listenReusePort(address string) (net.Listener, error) {
+	config := net.ListenConfig{
+		Control: func(network, address string, conn syscall.RawConn) error {
+			var err error
+
+			conn.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+
+			return err
+		},
+	}
+
+	return config.Listen(context.Background(), "tcp", address)
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package server
+
+import (
+	"fmt"
+	"net"
+)
+
+func listenReusePort(address string) (net.Listener, error) {
+	return nil, fmt.Errorf("server.reuse-port is not supported on this platform")
+}
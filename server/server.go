@@ -0,0 +1,344 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/joelchen/gothumb/internal/reporting"
+	"github.com/joelchen/gothumb/processor"
+	"github.com/joelchen/gothumb/source"
+	"github.com/joelchen/gothumb/storage"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/spf13/viper"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Filtered by log.levels.server
+var logger = logging.New("server")
+
+// Setup validates the config, which must have been read already, and
+// prepares image processing, sources, storage and webhooks from it. New
+// calls it; it is exported for generating thumbnails without serving them.
+func Setup() error {
+	if err := Validate(); err != nil {
+		return err
+	}
+
+	if err := reporting.Setup(Version); err != nil {
+		return err
+	}
+
+	processor.RegisterOperation("watermark", watermarkOperation)
+	processor.RegisterOperation("grayscale", grayscaleOperation)
+
+	if err := processor.Setup(); err != nil {
+		return err
+	}
+
+	source.Setup()
+
+	if viper.GetString("webhooks.url") != "" {
+		go sendWebhooks()
+	}
+
+	setupUploads()
+
+	if err := setupStatsd(); err != nil {
+		return err
+	}
+
+	if err := setupLocks(); err != nil {
+		return err
+	}
+
+	return storage.Setup()
+}
+
+// New sets up the pipeline, signing and access control from the config and
+// returns the handler serving thumbnails along with the health, discovery
+// and CORS routes
+func New() (http.Handler, error) {
+	if err := Setup(); err != nil {
+		return nil, err
+	}
+
+	if viper.GetBool("server.unsafe") {
+		logger.Warnf("server.unsafe is set, signatures are not validated")
+	}
+
+	if viper.GetBool("jwt.enabled") {
+		if err := loadTokenKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := setupRedirects(); err != nil {
+		return nil, err
+	}
+
+	if err := setupAccessLists(); err != nil {
+		return nil, err
+	}
+
+	if viper.GetString("audit.webhook") != "" {
+		go sendAuditEvents()
+	}
+
+	if err := setupUsage(); err != nil {
+		return nil, err
+	}
+
+	if err := setupBudgets(); err != nil {
+		return nil, err
+	}
+
+	setupRateLimiter()
+	setupPrefetch()
+	handler, err := chain(reportPanics(newRouter()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return withSystemRoutes(handler), nil
+}
+
+// Run serves the handler from New on server.port or server.socket, and the
+// admin listener when admin.address is set, until SIGINT or SIGTERM
+func Run() error {
+	handler, err := New()
+
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("gothumb %s (commit %s, built %s, %s, %s)", Version, Commit, BuildDate, processor.Default.Version(), runtime.Version())
+
+	if viper.GetString("admin.address") != "" {
+		go serveAdmin()
+	}
+
+	watchConfig()
+	server := newServer(handler)
+
+	if server.TLSConfig, err = tlsConfig(); err != nil {
+		return err
+	}
+
+	configureHTTP2(server)
+
+	if err = configureHTTP3(server); err != nil {
+		return err
+	}
+
+	if err = serveGRPC(server.TLSConfig); err != nil {
+		return err
+	}
+
+	listener, err := listen(server)
+
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		var err error
+
+		if server.TLSConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+
+		if err != http.ErrServerClosed {
+			listenerFailed(err)
+		}
+	}()
+
+	return waitForShutdown(server)
+}
+
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + strconv.Itoa(viper.GetInt("server.port")),
+		Handler:           handler,
+		ReadTimeout:       viper.GetDuration("server.read-timeout"),
+		ReadHeaderTimeout: viper.GetDuration("server.read-header-timeout"),
+		WriteTimeout:      viper.GetDuration("server.write-timeout"),
+		IdleTimeout:       viper.GetDuration("server.idle-timeout"),
+		ErrorLog:          logger.Std(logging.Warn),
+	}
+}
+
+// Listens on the socket passed by systemd socket activation, the unix
+// socket at server.socket when set, replacing a stale socket left by a
+// previous run and applying server.socket-mode, or on the server's TCP
+// address otherwise. With server.reuse-port the TCP port can be shared with
+// the next process during a restart.
+func listen(server *http.Server) (net.Listener, error) {
+	if listener, err := activationListener(); listener != nil || err != nil {
+		return listener, err
+	}
+
+	socket := viper.GetString("server.socket")
+
+	if socket == "" {
+		if viper.GetBool("server.reuse-port") {
+			return listenReusePort(server.Addr)
+		}
+
+		return net.Listen("tcp", server.Addr)
+	}
+
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", socket)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := strconv.ParseUint(viper.GetString("server.socket-mode"), 8, 32)
+
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Invalid server.socket-mode: %v", err)
+	}
+
+	if err = os.Chmod(socket, os.FileMode(mode)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// Returns the first socket systemd passed to this process, or nil when it
+// was not socket activated
+func activationListener() (net.Listener, error) {
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+		return nil, nil
+	}
+
+	if fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); fds < 1 {
+		return nil, nil
+	}
+
+	// Sockets start at file descriptor 3, after stdin, stdout and stderr
+	file := os.NewFile(3, "LISTEN_FD_3")
+	listener, err := net.FileListener(file)
+	file.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("Socket activation: %v", err)
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	return listener, nil
+}
+
+// Bounds the whole request, including source fetches and S3 calls, by
+// server.request-timeout
+func withDeadline(next http.Handler) http.Handler {
+	timeout := viper.GetDuration("server.request-timeout")
+
+	if timeout <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx, cancel := context.WithTimeout(request.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
+
+// Enables HTTP/2 on TLS listeners unless server.http2 is off, and
+// cleartext HTTP/2 (h2c) from trusted proxies when server.h2c is set
+func configureHTTP2(server *http.Server) {
+	if !viper.GetBool("server.http2") {
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+
+		if server.TLSConfig != nil {
+			var protos []string
+
+			for _, proto := range server.TLSConfig.NextProtos {
+				if proto != "h2" {
+					protos = append(protos, proto)
+				}
+			}
+
+			server.TLSConfig.NextProtos = protos
+		}
+
+		return
+	}
+
+	if server.TLSConfig != nil || !viper.GetBool("server.h2c") {
+		return
+	}
+
+	plain := server.Handler
+	upgrading := h2c.NewHandler(plain, &http2.Server{IdleTimeout: server.IdleTimeout})
+
+	server.Handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if trustedPeer(request.RemoteAddr) {
+			upgrading.ServeHTTP(writer, request)
+			return
+		}
+
+		plain.ServeHTTP(writer, request)
+	})
+}
+
+// Listener serving HTTP/3 over QUIC when server.http3 is set
+var quicServer *http3.Server
+
+// Starts an HTTP/3 listener on the same UDP port as the TLS server when
+// server.http3 is set, and advertises it to HTTP/1.1 and HTTP/2 clients via
+// Alt-Svc. Validate reports the settings it cannot be combined with.
+func configureHTTP3(server *http.Server) error {
+	if !viper.GetBool("server.http3") {
+		return nil
+	}
+
+	if server.TLSConfig == nil {
+		return fmt.Errorf("server.http3: requires TLS")
+	}
+
+	quicServer = &http3.Server{
+		Addr:        server.Addr,
+		Handler:     server.Handler,
+		TLSConfig:   http3.ConfigureTLSConfig(server.TLSConfig),
+		IdleTimeout: server.IdleTimeout,
+	}
+
+	next := server.Handler
+
+	server.Handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if err := quicServer.SetQUICHeaders(writer.Header()); err != nil {
+			logger.Errorf("%v", err)
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+
+	go func() {
+		if err := quicServer.ListenAndServe(); err != http.ErrServerClosed {
+			listenerFailed(err)
+		}
+	}()
+
+	return nil
+}
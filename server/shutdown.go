@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/joelchen/gothumb/internal/reporting"
+	"github.com/joelchen/gothumb/processor"
+	"github.com/spf13/viper"
+)
+
+// Cache writes and webhook deliveries still running after their response
+// was sent
+var background sync.WaitGroup
+
+func inBackground(task func()) {
+	background.Add(1)
+
+	go func() {
+		defer background.Done()
+		task()
+	}()
+}
+
+// Wait blocks until pending cache writes and webhook deliveries finish, for
+// commands that exit once they have generated thumbnails
+func Wait() {
+	background.Wait()
+}
+
+// Set once shutdown begins so readiness checks fail while the load balancer
+// moves traffic away
+var draining = struct {
+	sync.RWMutex
+	started bool
+}{}
+
+func isDraining() bool {
+	draining.RLock()
+	defer draining.RUnlock()
+
+	return draining.started
+}
+
+// Listeners that stopped serving, which shut the others down too
+var listenerFailures = make(chan error, 1)
+
+// Reports a listener failing, unless another already has
+func listenerFailed(err error) {
+	select {
+	case listenerFailures <- err:
+	default:
+		logger.Errorf("%v", err)
+	}
+}
+
+// Blocks until SIGINT or SIGTERM, keeps serving for server.drain-delay while
+// readiness reports draining, then stops accepting connections, lets
+// in-flight requests finish and waits for pending cache writes, giving up
+// after server.shutdown-timeout. A listener failing shuts down the same
+// way, without draining, and its error is returned.
+func waitForShutdown(server *http.Server) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	var failure error
+
+	select {
+	case received := <-signals:
+		logger.Infof("Received %s, shutting down", received)
+	case failure = <-listenerFailures:
+		logger.Errorf("Listener failed, shutting down")
+	}
+
+	if delay := viper.GetDuration("server.drain-delay"); delay > 0 && failure == nil {
+		draining.Lock()
+		draining.started = true
+		draining.Unlock()
+
+		logger.Infof("Draining for %s", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-signals:
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("server.shutdown-timeout"))
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Errorf("%v", err)
+	}
+
+	if quicServer != nil {
+		if err := quicServer.Shutdown(ctx); err != nil {
+			logger.Errorf("%v", err)
+		}
+	}
+
+	if grpcServer != nil {
+		stopGRPC(ctx)
+	}
+
+	if err := saveUsage(); err != nil {
+		logger.Errorf("Saving usage: %v", err)
+	}
+
+	if err := saveBudgets(); err != nil {
+		logger.Errorf("Saving budgets: %v", err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		// Requests still running after a timed out shutdown may use libvips
+		if ctx.Err() == nil {
+			processor.Shutdown()
+		}
+	case <-ctx.Done():
+		logger.Warnf("Shutdown timed out with cache writes or webhooks pending")
+	}
+
+	reporting.Flush(2 * time.Second)
+	return failure
+}
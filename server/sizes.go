@@ -0,0 +1,182 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joelchen/gothumb/signing"
+	"github.com/julienschmidt/httprouter"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Entries of the sizes setting are either "WIDTHxHEIGHT" or a table with
+// the dimensions under size and any of these options, which override the
+// global ones for that size:
+//
+//	quality    encoding quality instead of vips.quality
+//	fit        "cover" to crop to the exact size, "contain" to fit within it,
+//	           instead of vips.crop
+//	crop       gravity to crop around instead of vips.gravity
+//	format     output format, served whatever the client accepts
+//	watermark  overlay hotlink.watermark on every thumbnail
+//	max-age    Cache-Control max-age instead of cache-control.max-age
+//
+// Tenants with sizes of their own use those instead.
+func sizeEntries(t *tenant) map[string]interface{} {
+	return current().sizeEntries(t)
+}
+
+func (s settings) sizeEntries(t *tenant) map[string]interface{} {
+	return cast.ToStringMap(s.Get(s.tenantKey(t, "sizes")))
+}
+
+// Returns the names of the configured sizes, sorted
+func sizeNames(t *tenant) []string {
+	return current().sizeNames(t)
+}
+
+func (s settings) sizeNames(t *tenant) []string {
+	entries := s.sizeEntries(t)
+	names := make([]string, 0, len(entries))
+
+	for name := range entries {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Returns the WIDTHxHEIGHT of a configured size
+func sizeDimensions(t *tenant, name string) (string, bool) {
+	return current().sizeDimensions(t, name)
+}
+
+func (s settings) sizeDimensions(t *tenant, name string) (string, bool) {
+	entry, ok := s.sizeEntries(t)[name]
+
+	if !ok {
+		return "", false
+	}
+
+	if dimensions, ok := entry.(string); ok {
+		return dimensions, true
+	}
+
+	return cast.ToString(cast.ToStringMap(entry)["size"]), true
+}
+
+// Returns an option of a size given as a table, or nil when it does not
+// set it
+func sizeOption(t *tenant, name, key string) interface{} {
+	return current().sizeOption(t, name, key)
+}
+
+func (s settings) sizeOption(t *tenant, name, key string) interface{} {
+	return cast.ToStringMap(s.sizeEntries(t)[name])[key]
+}
+
+// Accepts a WIDTHxHEIGHT that is not a configured size when dynamic-sizes
+// is enabled and each side is listed in dynamic-sizes.widths or
+// dynamic-sizes.heights, or without a list, lies between dynamic-sizes.min
+// and dynamic-sizes.max on a multiple of dynamic-sizes.step. Sizes are only
+// accepted written the one way, without leading zeros, so each is cached
+// once.
+func (s settings) dynamicSize(str string) (width, height int, ok bool) {
+	if !s.GetBool("dynamic-sizes.enabled") {
+		return 0, 0, false
+	}
+
+	parts := strings.Split(str, "x")
+
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	width, wok := s.dynamicSide(parts[0], "dynamic-sizes.widths")
+	height, hok := s.dynamicSide(parts[1], "dynamic-sizes.heights")
+
+	if !wok || !hok || width == 0 && height == 0 {
+		return 0, 0, false
+	}
+
+	return width, height, true
+}
+
+func (s settings) dynamicSide(str, listKey string) (int, bool) {
+	n, err := strconv.Atoi(str)
+
+	if err != nil || strconv.Itoa(n) != str {
+		return 0, false
+	}
+
+	if list := s.GetIntSlice(listKey); len(list) > 0 {
+		for _, allowed := range list {
+			if n == allowed {
+				return n, true
+			}
+		}
+
+		return 0, false
+	}
+
+	min, max, step := s.GetInt("dynamic-sizes.min"), s.GetInt("dynamic-sizes.max"), s.GetInt("dynamic-sizes.step")
+
+	if n < min || n > max || step > 0 && (n-min)%step != 0 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// Whether a size segment names a size, after resolving aliases
+func knownSize(t *tenant, str string) bool {
+	return current().knownSize(t, str)
+}
+
+func (s settings) knownSize(t *tenant, str string) bool {
+	size := s.resolveSize(t, str)
+
+	if s.isOriginal(size) {
+		return true
+	}
+
+	_, _, err := s.parseWidthAndHeight(t, size)
+	return err == nil
+}
+
+// Returns the params of a thumbnail path, given those the router matched
+// or nil when it matched none. With default-size set, a path whose size
+// segment is not a size of the tenant, or that has none, is read as a
+// source at the default size, so URLs without sizes keep working.
+func thumbnailParams(t *tenant, path string, params httprouter.Params) (httprouter.Params, bool) {
+	size := viper.GetString("default-size")
+
+	if size == "" || params != nil && knownSize(t, params.ByName("size")) {
+		return params, params != nil
+	}
+
+	var signature string
+
+	if signing.InPath() {
+		parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+
+		if len(parts) != 2 {
+			return nil, false
+		}
+
+		signature, path = parts[0], "/"+parts[1]
+	}
+
+	if path == "/" {
+		return nil, false
+	}
+
+	return httprouter.Params{
+		{Key: "signature", Value: signature},
+		{Key: "size", Value: size},
+		{Key: "source", Value: path},
+	}, true
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"expvar"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Largest UDP payload that is not fragmented on common networks
+const maxStatsdPacket = 1432
+
+// Metric lines waiting to be sent, nil when statsd.address is not set so
+// nothing is queued
+var statsdLines chan string
+
+// Characters with a meaning in the StatsD protocol
+var statsdNames = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", " ", "_")
+
+// Pushes the counters published at /debug/vars to the StatsD server at
+// statsd.address every statsd.interval, as gauges named by statsd.prefix
+// followed by the map and key, along with the duration of every request.
+// With statsd.format set to "datadog", lines carry statsd.tags and requests
+// are tagged with their status class.
+func setupStatsd() error {
+	address := viper.GetString("statsd.address")
+
+	if address == "" {
+		return nil
+	}
+
+	conn, err := net.Dial("udp", address)
+
+	if err != nil {
+		return err
+	}
+
+	statsdLines = make(chan string, 1000)
+	go sendStatsd(conn)
+	go pushStats()
+	return nil
+}
+
+// Queues a metric, dropping it rather than waiting when the queue is full
+func statsdMetric(name, value, kind string, tags ...string) {
+	if statsdLines == nil {
+		return
+	}
+
+	line := viper.GetString("statsd.prefix") + statsdNames.Replace(name) + ":" + value + "|" + kind
+
+	if viper.GetString("statsd.format") == "datadog" {
+		if tags = append(viper.GetStringSlice("statsd.tags"), tags...); len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+	}
+
+	select {
+	case statsdLines <- line:
+	default:
+	}
+}
+
+// Packs queued lines into packets, sending each once full or at least
+// every second
+func sendStatsd(conn net.Conn) {
+	var packet []byte
+	ticker := time.NewTicker(time.Second)
+
+	for {
+		select {
+		case line := <-statsdLines:
+			if len(packet) > 0 && len(packet)+1+len(line) > maxStatsdPacket {
+				conn.Write(packet)
+				packet = packet[:0]
+			}
+
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+
+			packet = append(packet, line...)
+		case <-ticker.C:
+			if len(packet) > 0 {
+				conn.Write(packet)
+				packet = packet[:0]
+			}
+		}
+	}
+}
+
+// Sends every expvar map's numbers, and the goroutine count and heap size,
+// as gauges
+func pushStats() {
+	for range time.Tick(viper.GetDuration("statsd.interval")) {
+		expvar.Do(func(kv expvar.KeyValue) {
+			if m, ok := kv.Value.(*expvar.Map); ok {
+				m.Do(func(entry expvar.KeyValue) {
+					switch entry.Value.(type) {
+					case *expvar.Int, *expvar.Float:
+						statsdMetric(kv.Key+"."+entry.Key, entry.Value.String(), "g")
+					}
+				})
+			}
+		})
+
+		var memory runtime.MemStats
+		runtime.ReadMemStats(&memory)
+		statsdMetric("runtime.goroutines", strconv.Itoa(runtime.NumGoroutine()), "g")
+		statsdMetric("runtime.heap_bytes", strconv.FormatUint(memory.HeapAlloc, 10), "g")
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/joelchen/gothumb/signing"
+	"github.com/joelchen/gothumb/storage"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// A property served from its own hostnames, configured under
+// tenants.<name>. Any of these apply to its requests instead of the
+// top-level settings:
+//
+//	hosts          Host headers the tenant answers to
+//	key, keys      signing keys, like server.key and server.keys
+//	bucket         bucket holding sources without a host, instead of s3.bucket
+//	sources        source prefixes it may request, like clients.<id>.sources
+//	cache-prefix   where its thumbnails are cached under cache/, by default
+//	               its name
+//	sizes          its sizes, instead of sizes
+//	cache-control  caching headers, instead of cache-control
+//
+// A nil tenant stands for the top-level settings, used for hosts no tenant
+// lists.
+type tenant struct {
+	Name string
+}
+
+// Returns the tenant listing the request's host
+func requestTenant(request *http.Request) *tenant {
+	host := request.Host
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return tenantForHost(host)
+}
+
+func tenantForHost(host string) *tenant {
+	for _, name := range tenantNames() {
+		for _, tenantHost := range viper.GetStringSlice("tenants." + name + ".hosts") {
+			if strings.EqualFold(tenantHost, host) {
+				return &tenant{Name: name}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the tenant configured under the name, or an error when there is
+// none
+func lookupTenant(name string) (*tenant, error) {
+	name = strings.ToLower(name)
+
+	if name == "" || strings.Contains(name, ".") || !viper.IsSet("tenants."+name) {
+		return nil, fmt.Errorf("Unknown tenant")
+	}
+
+	return &tenant{Name: name}, nil
+}
+
+// Returns the names of the configured tenants, sorted
+func tenantNames() []string {
+	return current().tenantNames()
+}
+
+func (s settings) tenantNames() []string {
+	tenants := cast.ToStringMap(s.Get("tenants"))
+	names := make([]string, 0, len(tenants))
+
+	for name := range tenants {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Returns the key to read a setting from: the tenant's own when it sets
+// it, and the top-level one otherwise
+func (t *tenant) key(key string) string {
+	return current().tenantKey(t, key)
+}
+
+func (s settings) tenantKey(t *tenant, key string) string {
+	if t != nil && s.IsSet("tenants."+t.Name+"."+key) {
+		return "tenants." + t.Name + "." + key
+	}
+
+	return key
+}
+
+// Returns the keys the tenant's URLs are signed with, which replace the
+// server's once it has any
+func (t *tenant) signingKeys() []signing.Key {
+	if t == nil {
+		return signing.Keys()
+	}
+
+	prefix := "tenants." + t.Name
+
+	if keys := signing.KeysFrom(signing.DefaultAlgorithm(), viper.GetString(prefix+".key"), viper.Get(prefix+".keys")); len(keys) > 0 {
+		return keys
+	}
+
+	return signing.Keys()
+}
+
+// Checks the source against the prefixes the tenant may request
+func (t *tenant) allows(source string) error {
+	if t == nil {
+		return nil
+	}
+
+	prefixes := viper.GetStringSlice("tenants." + t.Name + ".sources")
+
+	if len(prefixes) == 0 {
+		return nil
+	}
+
+	if !sourceAllowed(source, prefixes) {
+		return fmt.Errorf("Source not allowed for tenant")
+	}
+
+	return nil
+}
+
+// Returns the bucket sources without a host are read from
+func (t *tenant) sourceBucket() string {
+	if t != nil {
+		if bucket := viper.GetString("tenants." + t.Name + ".bucket"); bucket != "" {
+			return bucket
+		}
+	}
+
+	return storage.Bucket()
+}
+
+// Returns where a thumbnail of the source is cached, under the tenant's
+// cache prefix so tenants serving the same paths keep apart
+func (t *tenant) cachePath(source *url.URL, variant string) string {
+	path := cachePath(source, variant)
+
+	if t == nil {
+		return path
+	}
+
+	prefix := t.Name
+
+	if viper.IsSet("tenants." + t.Name + ".cache-prefix") {
+		prefix = viper.GetString("tenants." + t.Name + ".cache-prefix")
+	}
+
+	if prefix = strings.Trim(prefix, "/"); prefix == "" {
+		return path
+	}
+
+	return "cache/" + prefix + "/" + strings.TrimPrefix(path, "cache/")
+}
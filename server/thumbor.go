@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/joelchen/gothumb/processor"
+	"github.com/julienschmidt/httprouter"
+	"github.com/spf13/viper"
+)
+
+var (
+	// A thumbor size, whose sides may be left out or flipped with a minus
+	thumborSize = regexp.MustCompile(`^(-?)(\d*)x(-?)(\d*)$`)
+	// Manual crops, as AxB:CxD
+	thumborCrop = regexp.MustCompile(`^\d+x\d+:\d+x\d+$`)
+)
+
+// Reads a thumbor path into thumbnail params, with the signature given, and
+// the options it sets:
+//
+//	/signature/[fit-in/]WxH/[center/][middle/][smart/][filters:.../]source
+//
+// The size is a WIDTHxHEIGHT or a size name, or default-size when left out.
+// Paths using none of the options are served as their size is configured,
+// and those that do get thumbor's defaults: filling the size around the
+// centre, or fitting within it without enlarging for fit-in. Returns nil
+// params for paths with no size.
+func thumborParams(request *http.Request, signature string) (httprouter.Params, *urlOptions, error) {
+	t := requestTenant(request)
+	// The signature segment
+	segments := strings.Split(strings.TrimPrefix(request.URL.Path, "/"), "/")[1:]
+	var fitIn, given bool
+
+	for len(segments) > 0 {
+		segment := segments[0]
+
+		if segment == "meta" || segment == "trim" || strings.HasPrefix(segment, "trim:") || thumborCrop.MatchString(segment) || strings.HasSuffix(segment, "fit-in") && segment != "fit-in" {
+			return nil, nil, fmt.Errorf("Unsupported option: %s", segment)
+		}
+
+		if segment != "fit-in" {
+			break
+		}
+
+		fitIn, given = true, true
+		segments = segments[1:]
+	}
+
+	size := viper.GetString("default-size")
+
+	if len(segments) > 0 {
+		if match := thumborSize.FindStringSubmatch(segments[0]); match != nil {
+			if match[1] != "" || match[3] != "" {
+				return nil, nil, fmt.Errorf("Flipping is not supported")
+			}
+
+			size = thumborSide(match[2]) + "x" + thumborSide(match[4])
+			segments = segments[1:]
+		} else if knownSize(t, segments[0]) {
+			size = segments[0]
+			segments = segments[1:]
+		}
+	}
+
+	if size == "" || len(segments) == 0 {
+		return nil, nil, nil
+	}
+
+	options := &urlOptions{Crop: !fitIn, Gravity: "centre", Enlarge: !fitIn}
+
+options:
+	for len(segments) > 0 {
+		switch segment := segments[0]; {
+		// The processors only align crops to the centre
+		case segment == "center" || segment == "middle":
+		case segment == "left" || segment == "right" || segment == "top" || segment == "bottom":
+			return nil, nil, fmt.Errorf("Unsupported alignment: %s", segment)
+		case segment == "smart":
+			options.Gravity = "smart"
+		case strings.HasPrefix(segment, "filters:"):
+			if err := thumborFilters(options, strings.TrimPrefix(segment, "filters:")); err != nil {
+				return nil, nil, err
+			}
+		default:
+			break options
+		}
+
+		given = true
+		segments = segments[1:]
+	}
+
+	if len(segments) == 0 || segments[0] == "" && len(segments) == 1 {
+		return nil, nil, fmt.Errorf("Missing source")
+	}
+
+	if !given {
+		options = nil
+	}
+
+	return httprouter.Params{
+		{Key: "signature", Value: signature},
+		{Key: "size", Value: size},
+		{Key: "source", Value: "/" + strings.Join(segments, "/")},
+	}, options, nil
+}
+
+// Returns a side of a thumbor size, where left out means fitting the other
+func thumborSide(side string) string {
+	n, _ := strconv.Atoi(side)
+	return strconv.Itoa(n)
+}
+
+// Applies filters such as quality(80):grayscale() to the options
+func thumborFilters(options *urlOptions, filters string) error {
+	for filters != "" {
+		open, end := strings.Index(filters, "("), strings.Index(filters, ")")
+
+		if open <= 0 || end < open {
+			return fmt.Errorf("Invalid filters: %s", filters)
+		}
+
+		name, arg := filters[:open], filters[open+1:end]
+		filters = strings.TrimPrefix(filters[end+1:], ":")
+
+		switch name {
+		case "quality":
+			quality, err := strconv.Atoi(arg)
+
+			if err != nil || quality < 1 || quality > 100 {
+				return fmt.Errorf("quality: %q is not between 1 and 100", arg)
+			}
+
+			options.Quality = quality
+		case "format":
+			format := strings.ToLower(arg)
+
+			if format == "jpg" {
+				format = "jpeg"
+			}
+
+			if !containsString(processor.Default.Formats(), format) {
+				return fmt.Errorf("Unsupported format: %s", arg)
+			}
+
+			options.Format = format
+		case "grayscale":
+			options.Grayscale = true
+		case "upscale":
+			options.Enlarge = true
+		case "no_upscale":
+			options.Enlarge = false
+		default:
+			return fmt.Errorf("Unsupported filter: %s", name)
+		}
+	}
+
+	return nil
+}
+
+// Turns every thumbnail gray when listed in the operations setting
+func grayscaleOperation(data []byte, contentType string) ([]byte, string, error) {
+	return applyGrayscale(data, contentType, viper.GetInt("vips.quality"))
+}
+
+// Redraws an encoded image in shades of gray, returning it with the content
+// type it was encoded as
+func applyGrayscale(buf []byte, contentType string, quality int) ([]byte, string, error) {
+	img, _, err := image.Decode(bytes.NewReader(buf))
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+	return encodeDrawn(gray, contentType, quality)
+}
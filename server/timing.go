@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Stages of a request that are timed, in the order they run:
+//
+//	auth         checking the signature, token or client
+//	cache-read   fetching a cached thumbnail from the bucket
+//	fetch        fetching and reading the source
+//	queue        waiting for a resize worker
+//	decode       decoding the source
+//	resize       resizing and encoding the thumbnails
+//	cache-write  storing a thumbnail in the bucket, after the response
+var stageStats = expvar.NewMap("stages")
+
+// Upper bounds of the histogram buckets, in milliseconds
+var stageBounds = []float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Latency histogram of one stage, published as JSON with the count and sum
+// of durations, and the count at or under each bound
+type stageHistogram struct {
+	sync.Mutex
+	count  int64
+	sum    float64
+	counts []int64
+}
+
+func (h *stageHistogram) observe(ms float64) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.count++
+	h.sum += ms
+
+	for i, bound := range stageBounds {
+		if ms <= bound {
+			h.counts[i]++
+		}
+	}
+}
+
+func (h *stageHistogram) String() string {
+	h.Lock()
+	defer h.Unlock()
+
+	buckets := make(map[string]int64, len(stageBounds)+1)
+
+	for i, bound := range stageBounds {
+		buckets[strconv.FormatFloat(bound, 'f', -1, 64)] = h.counts[i]
+	}
+
+	buckets["+Inf"] = h.count
+
+	data, _ := json.Marshal(struct {
+		Count   int64            `json:"count"`
+		Sum     float64          `json:"sum_ms"`
+		Buckets map[string]int64 `json:"le"`
+	}{h.count, h.sum, buckets})
+
+	return string(data)
+}
+
+var stageHistograms = struct {
+	sync.Mutex
+	byName map[string]*stageHistogram
+}{byName: map[string]*stageHistogram{}}
+
+func stageHistogramFor(name string) *stageHistogram {
+	stageHistograms.Lock()
+	defer stageHistograms.Unlock()
+
+	h, ok := stageHistograms.byName[name]
+
+	if !ok {
+		h = &stageHistogram{counts: make([]int64, len(stageBounds))}
+		stageHistograms.byName[name] = h
+		stageStats.Set(name, h)
+	}
+
+	return h
+}
+
+type timingsKey struct{}
+
+// Time spent in each stage of one request, in the order the stages first
+// ran. Stages that run more than once, such as fetching a source and then
+// reading it, add up, so each is observed once per request when it ends.
+type stageTimings struct {
+	sync.Mutex
+	names     []string
+	durations map[string]time.Duration
+	finished  bool
+}
+
+// Returns the request with a context collecting its stage timings
+func withTimings(request *http.Request) *http.Request {
+	timings := &stageTimings{durations: map[string]time.Duration{}}
+	return request.WithContext(context.WithValue(request.Context(), timingsKey{}, timings))
+}
+
+// Records the time since start as spent in a stage
+func timeStage(ctx context.Context, name string, start time.Time) {
+	recordStage(ctx, name, time.Since(start))
+}
+
+// Adds the duration to the stage's timing for the request, or observes it
+// straight away for work done outside a request or after it ended
+func recordStage(ctx context.Context, name string, duration time.Duration) {
+	timings, ok := ctx.Value(timingsKey{}).(*stageTimings)
+
+	if !ok {
+		observeStage(name, duration)
+		return
+	}
+
+	timings.Lock()
+	defer timings.Unlock()
+
+	if timings.finished {
+		observeStage(name, duration)
+		return
+	}
+
+	if _, seen := timings.durations[name]; !seen {
+		timings.names = append(timings.names, name)
+	}
+
+	timings.durations[name] += duration
+}
+
+// Observes the stages of a request once it is done
+func finishTimings(ctx context.Context) {
+	timings, ok := ctx.Value(timingsKey{}).(*stageTimings)
+
+	if !ok {
+		return
+	}
+
+	timings.Lock()
+	defer timings.Unlock()
+
+	timings.finished = true
+
+	for _, name := range timings.names {
+		observeStage(name, timings.durations[name])
+	}
+}
+
+// Adds a duration to the stage's histogram and sends it as a StatsD timing
+func observeStage(name string, duration time.Duration) {
+	ms := float64(duration.Microseconds()) / 1000
+	stageHistogramFor(name).observe(ms)
+	statsdMetric("stage."+name+"_ms", strconv.FormatFloat(ms, 'f', -1, 64), "ms")
+}
+
+// Keeps the stage timings of a request in a context derived from another,
+// so work detached from the request is still timed as part of it
+func keepTimings(ctx, from context.Context) context.Context {
+	if timings, ok := from.Value(timingsKey{}).(*stageTimings); ok {
+		return context.WithValue(ctx, timingsKey{}, timings)
+	}
+
+	return ctx
+}
+
+// Sets the Server-Timing header to the stages timed so far when
+// server.server-timing or server.debug-headers is enabled, so browsers'
+// developer tools and RUM agents can show where a slow response spent its
+// time
+func setServerTiming(ctx context.Context, header http.Header) {
+	if !viper.GetBool("server.server-timing") && !viper.GetBool("server.debug-headers") {
+		return
+	}
+
+	timings, ok := ctx.Value(timingsKey{}).(*stageTimings)
+
+	if !ok {
+		return
+	}
+
+	timings.Lock()
+	defer timings.Unlock()
+
+	if len(timings.names) == 0 {
+		return
+	}
+
+	entries := make([]string, len(timings.names))
+
+	for i, name := range timings.names {
+		ms := float64(timings.durations[name].Microseconds()) / 1000
+		entries[i] = name + ";dur=" + strconv.FormatFloat(ms, 'f', -1, 64)
+	}
+
+	header.Set("Server-Timing", strings.Join(entries, ", "))
+}
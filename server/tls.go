@@ -0,0 +1,145 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Serves a certificate from disk, reloading it whenever either file changes
+type certReloader struct {
+	mu       sync.Mutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+
+		if err != nil {
+			return latest, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.latestModTime()
+
+	if err != nil {
+		return err
+	}
+
+	if !modTime.After(r.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+
+	if err != nil {
+		return err
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Keep serving the previous certificate if the new one is unreadable,
+	// which also covers the window where only one file has been replaced
+	if err := r.reload(); err != nil {
+		logger.Errorf("%v", err)
+	}
+
+	return r.cert, nil
+}
+
+// Returns the TLS configuration for the listener, or nil to serve plain
+// HTTP, requiring client certificates signed by server.tls.client-ca when
+// that is set
+func tlsConfig() (*tls.Config, error) {
+	config, err := serverTLSConfig()
+
+	if err != nil || config == nil {
+		return config, err
+	}
+
+	if caFile := viper.GetString("server.tls.client-ca"); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", caFile)
+		}
+
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// Certificates come either from server.tls.cert/key or from Let's Encrypt
+// for the domains in server.tls.autocert.domains
+func serverTLSConfig() (*tls.Config, error) {
+	if domains := viper.GetStringSlice("server.tls.autocert.domains"); len(domains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(viper.GetString("server.tls.autocert.cache-dir")),
+			Email:      viper.GetString("server.tls.autocert.email"),
+		}
+
+		return manager.TLSConfig(), nil
+	}
+
+	certFile := viper.GetString("server.tls.cert")
+	keyFile := viper.GetString("server.tls.key")
+
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	reloader, err := newCertReloader(certFile, keyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{GetCertificate: reloader.GetCertificate}, nil
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/spf13/viper"
+)
+
+// Settings that can be changed at /toggles, each with a check of the new
+// value. Changes override the config until gothumb restarts, reloads
+// included.
+var toggles = map[string]func(string) error{
+	"log.level":            checkLevel,
+	"server.debug-headers": checkBool,
+	"prefetch.paused":      checkBool,
+	"server.unsafe": func(value string) error {
+		// Signatures can be turned back on but never off from here
+		if enabled, err := strconv.ParseBool(value); err != nil || enabled {
+			return fmt.Errorf("can only be set to false")
+		}
+
+		return nil
+	},
+}
+
+func checkLevel(value string) error {
+	_, err := logging.ParseLevel(value)
+	return err
+}
+
+func checkBool(value string) error {
+	_, err := strconv.ParseBool(value)
+	return err
+}
+
+// Shows the toggles on GET, and on POST changes those given as form values
+// named by their setting. An empty value goes back to the config's, except
+// for log.levels.<subsystem>, where it leaves the subsystem at log.level.
+// Requests must carry admin.token as a bearer token:
+//
+//	curl -X POST -H 'Authorization: Bearer ...' 'localhost:6060/toggles?log.levels.source=debug&prefetch.paused=true'
+func handleToggles(writer http.ResponseWriter, request *http.Request) {
+	if !authorizeAdmin(writer, request) {
+		return
+	}
+
+	switch request.Method {
+	case "GET":
+	case "POST":
+		if err := request.ParseForm(); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		changes, err := toggleChanges(request.Form)
+
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for key, value := range changes {
+			switch {
+			case strings.HasPrefix(key, "log.levels."):
+				// Overriding one key of a map hides the rest of it, so the
+				// whole map is set
+				levels := viper.GetStringMapString("log.levels")
+				delete(levels, strings.TrimPrefix(key, "log.levels."))
+
+				if value != "" {
+					levels[strings.TrimPrefix(key, "log.levels.")] = value
+				}
+
+				viper.Set("log.levels", levels)
+			case value == "":
+				// Overrides set to nil fall through to the config
+				viper.Set(key, nil)
+			default:
+				viper.Set(key, value)
+			}
+
+			logger.Warn("setting toggled", "key", key, "value", value, "client_ip", clientIP(request))
+		}
+	default:
+		writer.Header().Set("Allow", "GET, POST")
+		http.Error(writer, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(writer).Encode(toggleValues())
+}
+
+// Checks every form value before any is applied, so a bad one changes
+// nothing
+func toggleChanges(form map[string][]string) (map[string]string, error) {
+	changes := map[string]string{}
+
+	for key, values := range form {
+		value := values[len(values)-1]
+		check, ok := toggles[key]
+
+		if strings.HasPrefix(key, "log.levels.") {
+			check, ok = checkLevel, true
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("%s: cannot be toggled", key)
+		}
+
+		if value != "" {
+			if err := check(value); err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+		}
+
+		changes[key] = value
+	}
+
+	return changes, nil
+}
+
+// Returns the current value of every toggle, which are true or false apart
+// from the log levels
+func toggleValues() map[string]interface{} {
+	values := map[string]interface{}{}
+
+	for key := range toggles {
+		values[key] = viper.GetBool(key)
+	}
+
+	values["log.level"] = viper.GetString("log.level")
+	values["log.levels"] = viper.GetStringMapString("log.levels")
+	return values
+}
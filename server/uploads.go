@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	uploadStats = expvar.NewMap("uploads")
+	uploadQueue chan upload
+)
+
+// A result waiting to be stored, with the request ID it was generated for
+type upload struct {
+	ctx    context.Context
+	result *result
+}
+
+// Starts uploads.workers goroutines storing results queued by storeResult
+func setupUploads() {
+	uploadQueue = make(chan upload, viper.GetInt("uploads.queue"))
+
+	for i := 0; i < viper.GetInt("uploads.workers"); i++ {
+		go uploadWorker()
+	}
+}
+
+// Queues a result to be stored in the cache bucket, dropping it when the
+// queue is full; it will be generated again on the next request
+func storeResult(ctx context.Context, result *result) {
+	background.Add(1)
+
+	select {
+	case uploadQueue <- upload{detachContext(ctx), result}:
+		uploadStats.Add("queued", 1)
+	default:
+		background.Done()
+		releaseLease(result.Path)
+		uploadStats.Add("dropped", 1)
+		logger.Warnf("uploads: queue full, dropping %s", result.Path)
+	}
+}
+
+func uploadWorker() {
+	for u := range uploadQueue {
+		uploadStats.Add("queued", -1)
+
+		if err := uploadWithRetry(u); err != nil {
+			uploadStats.Add("failed", 1)
+			reportStorageError(u.ctx, err)
+			deadLetter(u.result, err)
+		} else {
+			uploadStats.Add("stored", 1)
+		}
+
+		releaseLease(u.result.Path)
+
+		background.Done()
+	}
+}
+
+// Stores a result, retrying up to uploads.retries times with a backoff
+// that doubles from uploads.backoff
+func uploadWithRetry(u upload) error {
+	backoff := viper.GetDuration("uploads.backoff")
+	var err error
+
+	for attempt := 0; attempt <= viper.GetInt("uploads.retries"); attempt++ {
+		if attempt > 0 {
+			uploadStats.Add("retried", 1)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		ctx, cancel := context.WithTimeout(u.ctx, viper.GetDuration("uploads.timeout"))
+		err = putResult(ctx, u.result)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+type deadLetterEntry struct {
+	Time  time.Time `json:"time"`
+	Path  string    `json:"path"`
+	Size  string    `json:"size"`
+	Bytes int64     `json:"bytes"`
+	Error string    `json:"error"`
+}
+
+// Records an upload that failed every retry as a JSON line in
+// uploads.dead-letter, or in the log when unset
+func deadLetter(result *result, err error) {
+	line, _ := json.Marshal(&deadLetterEntry{
+		Time:  time.Now().UTC(),
+		Path:  result.Path,
+		Size:  result.Size,
+		Bytes: result.ContentLength,
+		Error: err.Error(),
+	})
+
+	file := viper.GetString("uploads.dead-letter")
+
+	if file == "" {
+		logger.Errorf("uploads: dead letter %s", line)
+		return
+	}
+
+	f, ferr := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+	if ferr != nil {
+		logger.Errorf("uploads: dead letter %s (%v)", line, ferr)
+		return
+	}
+
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/joelchen/gothumb/signing"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Processing options given in the URL rather than by its size, as the
+// imgproxy and thumbor syntaxes allow. They override those of the size.
+type urlOptions struct {
+	Crop    bool
+	Gravity string
+	Enlarge bool
+	Quality int
+	// Drawn with the standard library after resizing, like watermarks
+	Grayscale bool
+	// Output format from the extension or a filter, if any
+	Format string
+}
+
+type urlOptionsKey struct{}
+
+// Returns the cache directory suffix for the options, leaving out the
+// format, which thumbnail.variant adds
+func (o *urlOptions) variant() string {
+	parts := []string{"fit"}
+
+	if o.Crop {
+		parts = []string{"fill", o.Gravity}
+	}
+
+	if o.Enlarge {
+		parts = append(parts, "el")
+	}
+
+	if o.Quality > 0 {
+		parts = append(parts, "q"+strconv.Itoa(o.Quality))
+	}
+
+	if o.Grayscale {
+		parts = append(parts, "gray")
+	}
+
+	return strings.Join(parts, "-")
+}
+
+// Whether URLs carry processing options, in imgproxy's or thumbor's syntax
+func urlOptionsMode() bool {
+	return signing.ImgproxyMode() || signing.ThumborMode()
+}
+
+// Passes the handler the params of an imgproxy or thumbor URL, with the
+// options it gives in the request's context, or responds 404 or with the
+// error reading them
+func withURLOptions(handle httprouter.Handle) httprouter.Handle {
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		params, options, err := urlOptionParams(request, params.ByName("signature"))
+
+		if err != nil {
+			httpError(writer, request, err, 624)
+			return
+		}
+
+		if params == nil {
+			http.NotFound(writer, request)
+			return
+		}
+
+		ctx := context.WithValue(request.Context(), urlOptionsKey{}, options)
+		handle(writer, request.WithContext(ctx), params)
+	}
+}
+
+// Reads the URL in the syntax of the mode in use, returning nil params when
+// it asks for no thumbnail
+func urlOptionParams(request *http.Request, signature string) (httprouter.Params, *urlOptions, error) {
+	if signing.ImgproxyMode() {
+		return imgproxyParams(request, signature)
+	}
+
+	return thumborParams(request, signature)
+}
+
+// Returns the options a request gave in its URL, or nil for none
+func requestURLOptions(request *http.Request) *urlOptions {
+	options, _ := request.Context().Value(urlOptionsKey{}).(*urlOptions)
+	return options
+}
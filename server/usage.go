@@ -0,0 +1,363 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	errQuotaExceeded = fmt.Errorf("Monthly quota exceeded")
+	// What a quota counts, as set under <account>.quota
+	usageMetrics = []string{"requests", "pixels", "bytes"}
+)
+
+// Usage counted by month, as 2006-01 in UTC, and account. Accounts are named
+// after the config they come from, tenants.<name> or clients.<id>.
+var usage = struct {
+	sync.Mutex
+	months map[string]map[string]*usageCounts
+}{months: map[string]map[string]*usageCounts{}}
+
+type usageCounts struct {
+	Requests int64 `json:"requests"`
+	Pixels   int64 `json:"pixels"`
+	Bytes    int64 `json:"bytes"`
+}
+
+func (c *usageCounts) get(metric string) int64 {
+	switch metric {
+	case "requests":
+		return c.Requests
+	case "pixels":
+		return c.Pixels
+	default:
+		return c.Bytes
+	}
+}
+
+type usageKey struct{}
+
+// The accounts a request is billed to, known once it is authorized
+type usageRecord struct {
+	sync.Mutex
+	accounts []string
+}
+
+// Loads the counts saved to usage.file and keeps saving them every
+// usage.save-interval, so restarts do not lose the month so far
+func setupUsage() error {
+	file := viper.GetString("usage.file")
+
+	if !viper.GetBool("usage.enabled") || file == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		usage.Lock()
+		err = json.Unmarshal(data, &usage.months)
+		usage.Unlock()
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+
+	if interval := viper.GetDuration("usage.save-interval"); interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				if err := saveUsage(); err != nil {
+					logger.Errorf("Saving usage: %v", err)
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Writes the counts to usage.file
+func saveUsage() error {
+	file := viper.GetString("usage.file")
+
+	if !viper.GetBool("usage.enabled") || file == "" {
+		return nil
+	}
+
+	usage.Lock()
+	data, err := json.Marshal(usage.months)
+	usage.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(file, data)
+}
+
+// Writes a file through a temporary one beside it, so a crash never leaves
+// it half written
+func writeFileAtomic(file string, data []byte) error {
+	temp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp.Name())
+
+	if _, err = temp.Write(data); err == nil {
+		err = temp.Close()
+	} else {
+		temp.Close()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), file)
+}
+
+func usageMonth(now time.Time) string {
+	return now.UTC().Format("2006-01")
+}
+
+// Returns the request with a context recording usage when usage.enabled is
+// set
+func withUsage(request *http.Request) *http.Request {
+	if !viper.GetBool("usage.enabled") {
+		return request
+	}
+
+	return request.WithContext(context.WithValue(request.Context(), usageKey{}, &usageRecord{}))
+}
+
+// Keeps the accounts of a request in a context derived from another, so
+// thumbnails generated after it is gone are billed to it
+func keepUsage(ctx, from context.Context) context.Context {
+	if record, ok := from.Value(usageKey{}).(*usageRecord); ok {
+		return context.WithValue(ctx, usageKey{}, record)
+	}
+
+	return ctx
+}
+
+// Returns the accounts of the tenant and client a request is for
+func usageAccounts(t *tenant, c *client) []string {
+	var accounts []string
+
+	if t != nil {
+		accounts = append(accounts, "tenants."+t.Name)
+	}
+
+	if c != nil {
+		accounts = append(accounts, "clients."+c.ID)
+	}
+
+	return accounts
+}
+
+// Checks the monthly quotas of the tenant and client, returning the internal
+// code of usage.quota-status when one is used up
+func checkQuota(t *tenant, c *client) (int, error) {
+	if !viper.GetBool("usage.enabled") {
+		return 0, nil
+	}
+
+	month := usageMonth(time.Now())
+
+	usage.Lock()
+	defer usage.Unlock()
+
+	for _, account := range usageAccounts(t, c) {
+		counts, ok := usage.months[month][account]
+
+		if !ok {
+			continue
+		}
+
+		for _, metric := range usageMetrics {
+			if quota := quotaLimit(account, metric); quota > 0 && counts.get(metric) >= quota {
+				if viper.GetInt("usage.quota-status") == http.StatusPaymentRequired {
+					return 622, errQuotaExceeded
+				}
+
+				return 621, errQuotaExceeded
+			}
+		}
+	}
+
+	return 0, nil
+}
+
+// Bills the request to the tenant and client once it is authorized and
+// within its quotas, counting it
+func billRequest(request *http.Request, t *tenant, c *client) {
+	record, ok := request.Context().Value(usageKey{}).(*usageRecord)
+
+	if !ok {
+		return
+	}
+
+	record.Lock()
+	record.accounts = usageAccounts(t, c)
+	record.Unlock()
+
+	addUsage(request.Context(), usageCounts{Requests: 1})
+}
+
+// Counts the bytes of a response against the request's accounts
+func billResponse(ctx context.Context, bytes int64) {
+	addUsage(ctx, usageCounts{Bytes: bytes})
+}
+
+// Counts the pixels of generated thumbnails against the accounts of the
+// request they were generated for
+func billPixels(ctx context.Context, results []*result, thumbs []thumbnail) {
+	var pixels int64
+
+	for i, result := range results {
+		if config, _, err := image.DecodeConfig(bytes.NewReader(result.Data)); err == nil {
+			pixels += int64(config.Width) * int64(config.Height)
+		} else {
+			// Formats without a decoder, such as AVIF, count as their size
+			pixels += int64(thumbs[i].Width) * int64(thumbs[i].Height)
+		}
+	}
+
+	addUsage(ctx, usageCounts{Pixels: pixels})
+}
+
+func addUsage(ctx context.Context, delta usageCounts) {
+	record, ok := ctx.Value(usageKey{}).(*usageRecord)
+
+	if !ok {
+		return
+	}
+
+	record.Lock()
+	accounts := record.accounts
+	record.Unlock()
+
+	if len(accounts) == 0 {
+		return
+	}
+
+	month := usageMonth(time.Now())
+
+	usage.Lock()
+	defer usage.Unlock()
+
+	if usage.months[month] == nil {
+		usage.months[month] = map[string]*usageCounts{}
+	}
+
+	for _, account := range accounts {
+		counts, ok := usage.months[month][account]
+
+		if !ok {
+			counts = &usageCounts{}
+			usage.months[month][account] = counts
+		}
+
+		counts.Requests += delta.Requests
+		counts.Pixels += delta.Pixels
+		counts.Bytes += delta.Bytes
+	}
+}
+
+// Usage of one account in a month, with the quotas it has
+type accountUsage struct {
+	usageCounts
+	Quota map[string]int64 `json:"quota,omitempty"`
+}
+
+// Shows each account's usage for the month given as month, such as 2026-01,
+// by default the current one, along with the months counted. Requests must
+// carry admin.token as a bearer token:
+//
+//	curl -H 'Authorization: Bearer ...' 'localhost:6060/usage?month=2026-01'
+func handleUsage(writer http.ResponseWriter, request *http.Request) {
+	if !authorizeAdmin(writer, request) {
+		return
+	}
+
+	month := request.URL.Query().Get("month")
+
+	if month == "" {
+		month = usageMonth(time.Now())
+	}
+
+	if _, err := time.Parse("2006-01", month); err != nil {
+		http.Error(writer, "Invalid month", http.StatusBadRequest)
+		return
+	}
+
+	usage.Lock()
+	accounts := map[string]accountUsage{}
+
+	for account, counts := range usage.months[month] {
+		accounts[account] = accountUsage{usageCounts: *counts, Quota: accountQuota(account)}
+	}
+
+	months := make([]string, 0, len(usage.months))
+
+	for m := range usage.months {
+		months = append(months, m)
+	}
+
+	usage.Unlock()
+	sort.Strings(months)
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(writer).Encode(struct {
+		Month    string                  `json:"month"`
+		Months   []string                `json:"months"`
+		Accounts map[string]accountUsage `json:"accounts"`
+	}{month, months, accounts})
+}
+
+// Returns the monthly limit of a metric for the account, or 0 for none.
+// Bytes may be given as sizes such as "500GB".
+func quotaLimit(account, metric string) int64 {
+	if metric == "bytes" {
+		return int64(viper.GetSizeInBytes(account + ".quota.bytes"))
+	}
+
+	return viper.GetInt64(account + ".quota." + metric)
+}
+
+func accountQuota(account string) map[string]int64 {
+	quota := map[string]int64{}
+
+	for _, metric := range usageMetrics {
+		if limit := quotaLimit(account, metric); limit > 0 {
+			quota[metric] = limit
+		}
+	}
+
+	if len(quota) == 0 {
+		return nil
+	}
+
+	return quota
+}
@@ -0,0 +1,603 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/joelchen/gothumb/internal/secrets"
+	"github.com/joelchen/gothumb/sign"
+	"github.com/joelchen/gothumb/signing"
+	"github.com/spf13/cast"
+)
+
+// Keys shorter than this are too easy to brute force from signed URLs
+const minKeyLength = 16
+
+// Settings holding durations
+var durationSettings = []string{
+	"budgets.save-interval",
+	"concurrency.max-wait",
+	"deadlines.cache-read",
+	"deadlines.cache-write",
+	"deadlines.fetch",
+	"deadlines.queue",
+	"deadlines.resize",
+	"hot-cache.ttl",
+	"locks.poll",
+	"locks.ttl",
+	"log.rotation.every",
+	"log.rotation.max-age",
+	"redirect.expires",
+	"secrets.refresh",
+	"server.drain-delay",
+	"server.idle-timeout",
+	"server.key-grace",
+	"server.read-header-timeout",
+	"server.read-timeout",
+	"server.request-timeout",
+	"server.shutdown-timeout",
+	"server.write-timeout",
+	"source.breaker.cooldown",
+	"source.revalidate-after",
+	"source.transport.dial-timeout",
+	"source.transport.idle-conn-timeout",
+	"source.transport.keep-alive",
+	"source.transport.response-header-timeout",
+	"source.transport.tls-handshake-timeout",
+	"statsd.interval",
+	"uploads.backoff",
+	"uploads.timeout",
+	"usage.save-interval",
+	"vault.renew-before",
+	"watch.settle",
+}
+
+// Settings holding counts, by the smallest value that makes sense
+var countSettings = map[string]int{
+	"concurrency.max":                          0,
+	"log.sampling.initial":                     0,
+	"log.sampling.thereafter":                  1,
+	"log.rotation.max-backups":                 0,
+	"dynamic-sizes.min":                        0,
+	"dynamic-sizes.max":                        0,
+	"dynamic-sizes.step":                       1,
+	"workers.count":                            0,
+	"workers.queue":                            0,
+	"uploads.queue":                            0,
+	"uploads.workers":                          1,
+	"uploads.retries":                          0,
+	"webhooks.retries":                         0,
+	"queue.parallel":                           1,
+	"watch.parallel":                           1,
+	"prefetch.queue":                           0,
+	"rate-limit.burst":                         0,
+	"source.breaker.failures":                  0,
+	"vips.concurrency":                         0,
+	"vips.cache.operations":                    0,
+	"vips.cache.files":                         0,
+	"concurrency.retry-after":                  0,
+	"fallback.max-age":                         0,
+	"redirect.max-age":                         0,
+	"source.transport.max-idle-conns":          0,
+	"source.transport.max-idle-conns-per-host": 0,
+	"source.transport.max-conns-per-host":      0,
+}
+
+// Settings that read from or write to the cache bucket
+var bucketSettings = []string{"redirect.mode", "locks.redis", "prefetch.siblings"}
+
+// Validate checks the whole config up front, so a mistake is reported when
+// gothumb starts rather than by the first request it breaks. Every problem
+// found is listed in the error, one per line.
+func Validate() error {
+	return current().validate()
+}
+
+func (s settings) validate() error {
+	var problems []string
+
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	s.validateSizes(report, nil)
+
+	aliases := cast.ToStringMapString(s.Get("size-aliases"))
+	names := make([]string, 0, len(aliases))
+
+	for alias := range aliases {
+		names = append(names, alias)
+	}
+
+	sort.Strings(names)
+
+	for _, alias := range names {
+		if _, _, err := s.parseWidthAndHeight(nil, aliases[alias]); err != nil && !s.isOriginal(aliases[alias]) {
+			report("size-aliases.%s: %q is not a size", alias, aliases[alias])
+		}
+	}
+
+	if size := s.GetString("default-size"); size != "" && !s.knownSize(nil, size) {
+		report("default-size: %q is not a size", size)
+	}
+
+	s.validateKeys(report)
+
+	if s.GetBool("jwt.enabled") {
+		if _, err := s.readTokenKey(); err != nil {
+			report("%v", err)
+		}
+	}
+
+	s.validateTenants(report)
+	s.validateClients(report)
+	s.validateQuotas(report)
+	s.validateBudgets(report)
+
+	if s.GetBool("dynamic-sizes.enabled") {
+		listed := len(s.GetIntSlice("dynamic-sizes.widths")) > 0 && len(s.GetIntSlice("dynamic-sizes.heights")) > 0
+
+		if !listed && s.GetInt("dynamic-sizes.max") < s.GetInt("dynamic-sizes.min") {
+			report("dynamic-sizes.max: must be at least dynamic-sizes.min unless widths and heights are both listed")
+		}
+	}
+
+	if port := s.GetString("server.port"); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			report("server.port: %q is not a port number", port)
+		}
+	}
+
+	if rate, err := cast.ToFloat64E(s.Get("sentry.sample-rate")); err != nil || rate < 0 || rate > 1 {
+		report("sentry.sample-rate: %v is not between 0 and 1", s.Get("sentry.sample-rate"))
+	}
+
+	if quality, err := cast.ToIntE(s.Get("vips.quality")); err != nil || quality < 0 || quality > 100 {
+		report("vips.quality: %v is not between 0 and 100", s.Get("vips.quality"))
+	}
+
+	counts := make([]string, 0, len(countSettings))
+
+	for key := range countSettings {
+		counts = append(counts, key)
+	}
+
+	sort.Strings(counts)
+
+	for _, key := range counts {
+		value, err := cast.ToIntE(s.Get(key))
+
+		if err != nil {
+			report("%s: %q is not a number", key, s.GetString(key))
+		} else if value < countSettings[key] {
+			report("%s: %d is less than %d", key, value, countSettings[key])
+		}
+	}
+
+	for _, key := range durationSettings {
+		if !s.IsSet(key) {
+			continue
+		}
+
+		duration, err := cast.ToDurationE(s.Get(key))
+
+		if err != nil {
+			report("%s: %q is not a duration such as 500ms or 30s", key, s.GetString(key))
+		} else if duration < 0 {
+			report("%s: %v is negative", key, duration)
+		}
+	}
+
+	s.oneOf(report, "processor", "", "vips", "go")
+	s.oneOf(report, "server.signature-format", "", "thumbor", "imgproxy")
+	s.oneOf(report, "hotlink.action", "deny", "low-res", "watermark")
+	s.oneOf(report, "redirect.mode", "", "s3", "cloudfront")
+	s.oneOf(report, "log.access-format", "text", "json", "off")
+	s.oneOf(report, "log.format", "console", "json")
+	s.oneOf(report, "statsd.format", "statsd", "datadog")
+
+	if s.watermarks() {
+		if file := s.GetString("hotlink.watermark"); file == "" {
+			report("hotlink.watermark: required to watermark thumbnails")
+		} else if err := checkImage(file); err != nil {
+			report("hotlink.watermark: %v", err)
+		}
+	}
+
+	if s.GetString("statsd.address") != "" && s.GetDuration("statsd.interval") <= 0 {
+		report("statsd.interval: must be positive")
+	}
+
+	if s.IsSet("source.max-size") && s.GetSizeInBytes("source.max-size") == 0 && s.GetString("source.max-size") != "0" {
+		report("source.max-size: %q is not a size such as 10MB", s.GetString("source.max-size"))
+	}
+
+	if s.GetString("log.file") != "" && s.GetSizeInBytes("log.rotation.max-size") < 1<<20 {
+		report("log.rotation.max-size: %q is not a size of at least 1MB", s.GetString("log.rotation.max-size"))
+	}
+
+	if _, err := logging.ParseLevel(s.GetString("log.level")); err != nil {
+		report("log.level: %v", err)
+	}
+
+	for subsystem, level := range s.GetStringMapString("log.levels") {
+		if _, err := logging.ParseLevel(level); err != nil {
+			report("log.levels.%s: %v", subsystem, err)
+		}
+	}
+
+	for _, format := range s.GetStringSlice("formats.negotiate") {
+		switch format {
+		case "jpeg", "png", "webp", "avif":
+		default:
+			report("formats.negotiate: unknown format %q", format)
+		}
+	}
+
+	for _, key := range []string{"access.allow", "access.deny", "server.trusted-proxies"} {
+		if _, err := parseCIDRs(s.GetStringSlice(key)); err != nil {
+			report("%s: %v", key, err)
+		}
+	}
+
+	if !containsString(s.GetStringSlice("server.middleware"), "ip-filter") {
+		for _, key := range []string{"access.allow", "access.deny"} {
+			if len(s.GetStringSlice(key)) > 0 {
+				report("%s: requires ip-filter in server.middleware", key)
+			}
+		}
+	}
+
+	if s.GetString("server.socket") != "" {
+		if s.GetBool("server.reuse-port") {
+			report("server.reuse-port: cannot be used with server.socket")
+		}
+
+		if s.GetBool("server.http3") {
+			report("server.http3: cannot be used with server.socket")
+		}
+	}
+
+	hasTLS := s.GetString("server.tls.cert") != "" || len(s.GetStringSlice("server.tls.autocert.domains")) > 0
+
+	if s.GetBool("server.http3") && !hasTLS {
+		report("server.http3: requires server.tls.cert or server.tls.autocert")
+	}
+
+	if len(s.GetStringSlice("server.tls.autocert.domains")) > 0 && s.GetString("server.tls.cert") != "" {
+		report("server.tls.cert: cannot be used with server.tls.autocert")
+	}
+
+	if (s.GetString("server.tls.cert") == "") != (s.GetString("server.tls.key") == "") {
+		report("server.tls: cert and key must be set together")
+	}
+
+	if s.GetString("s3.bucket") == "" {
+		for _, key := range bucketSettings {
+			if s.GetString(key) != "" || len(s.GetStringMap(key)) > 0 {
+				report("%s: requires s3.bucket", key)
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("Invalid config:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// Checks that every size of the tenant is WIDTHxHEIGHT with at least one
+// side given, and the options of sizes given as tables
+func (s settings) validateSizes(report func(string, ...interface{}), t *tenant) {
+	key := s.tenantKey(t, "sizes")
+	names := s.sizeNames(t)
+
+	if len(names) == 0 {
+		report("%s: no sizes configured", key)
+	}
+
+	for _, name := range names {
+		dimensions, _ := s.sizeDimensions(t, name)
+		width, height, err := s.parseWidthAndHeight(t, name)
+
+		switch {
+		case err != nil:
+			report("%s.%s: %q is not WIDTHxHEIGHT", key, name, dimensions)
+		case width < 0 || height < 0:
+			report("%s.%s: %q has a negative side", key, name, dimensions)
+		case width == 0 && height == 0:
+			report("%s.%s: %q needs a width or a height", key, name, dimensions)
+		}
+
+		if quality := s.sizeOption(t, name, "quality"); quality != nil {
+			if n, err := cast.ToIntE(quality); err != nil || n < 1 || n > 100 {
+				report("%s.%s.quality: %v is not between 1 and 100", key, name, quality)
+			}
+		}
+
+		if maxAge := s.sizeOption(t, name, "max-age"); maxAge != nil {
+			if n, err := cast.ToIntE(maxAge); err != nil || n < 0 {
+				report("%s.%s.max-age: %v is not a number of seconds", key, name, maxAge)
+			}
+		}
+
+		if fit := s.sizeOption(t, name, "fit"); fit != nil && fit != "cover" && fit != "contain" {
+			report("%s.%s.fit: %q is not one of \"cover\", \"contain\"", key, name, cast.ToString(fit))
+		}
+
+		switch format := cast.ToString(s.sizeOption(t, name, "format")); format {
+		case "", "jpeg", "png", "webp", "avif":
+		default:
+			report("%s.%s.format: unknown format %q", key, name, format)
+		}
+
+		if watermark := s.sizeOption(t, name, "watermark"); watermark != nil {
+			if _, err := cast.ToBoolE(watermark); err != nil {
+				report("%s.%s.watermark: %v is not true or false", key, name, watermark)
+			}
+		}
+	}
+}
+
+// Checks that requests can be authorized at all, and that every signing key
+// is long enough and names a known algorithm
+func (s settings) validateKeys(report func(string, ...interface{})) {
+	keys := signing.KeysFrom(signing.DefaultAlgorithmFrom(s.Viper), secrets.GetFrom(s.Viper, "server.key"), s.Get("server.keys"))
+
+	if len(keys) == 0 && !s.keyOptional() {
+		report("server.key: required unless server.unsafe, jwt.enabled or clients are set")
+	}
+
+	for i, key := range keys {
+		name := "server.key"
+
+		if i > 0 || secrets.GetFrom(s.Viper, "server.key") == "" {
+			name = "server.keys"
+		}
+
+		s.validateKey(report, name, key)
+	}
+
+	if _, err := hex.DecodeString(secrets.GetFrom(s.Viper, "server.salt")); err != nil && signing.ImgproxyModeFrom(s.Viper) {
+		report("server.salt: must be hex encoded in imgproxy mode")
+	}
+}
+
+// Whether any thumbnail is drawn with hotlink.watermark: hotlinked ones,
+// those of sizes that set watermark, or every one through the operations
+func (s settings) watermarks() bool {
+	if s.GetString("hotlink.action") == "watermark" || containsString(s.GetStringSlice("operations"), "watermark") {
+		return true
+	}
+
+	tenants := []*tenant{nil}
+
+	for _, name := range s.tenantNames() {
+		tenants = append(tenants, &tenant{Name: name})
+	}
+
+	for _, t := range tenants {
+		for _, name := range s.sizeNames(t) {
+			if cast.ToBool(s.sizeOption(t, name, "watermark")) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Checks that a file opens as an image in a format that can be decoded
+func checkImage(file string) error {
+	f, err := os.Open(file)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	_, _, err = image.DecodeConfig(f)
+	return err
+}
+
+// Whether requests can be authorized without server.key, as they are not
+// checked at all, or carry tokens or sign with client secrets
+func (s settings) keyOptional() bool {
+	return s.GetBool("server.unsafe") || s.GetBool("jwt.enabled") || s.IsSet("clients")
+}
+
+// Checks that a signing key is long enough, names a known algorithm and is
+// hex encoded when imgproxy signatures need it
+func (s settings) validateKey(report func(string, ...interface{}), name string, key signing.Key) {
+	if len(key.Secret) < minKeyLength {
+		report("%s: keys must be at least %d characters", name, minKeyLength)
+	}
+
+	if _, ok := sign.Algorithms[strings.ToLower(key.Algorithm)]; !ok {
+		report("%s: unknown algorithm %q", name, key.Algorithm)
+	}
+
+	if _, err := hex.DecodeString(key.Secret); err != nil && signing.ImgproxyModeFrom(s.Viper) {
+		report("%s: keys must be hex encoded in imgproxy mode", name)
+	}
+}
+
+// Checks that every client has a secret, that its secrets are keys the way
+// server.key is, and that it is limited to sizes that exist
+func (s settings) validateClients(report func(string, ...interface{})) {
+	clients := cast.ToStringMap(s.Get("clients"))
+	ids := make([]string, 0, len(clients))
+
+	for id := range clients {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		prefix := "clients." + id
+		c, err := s.lookupClient(id)
+
+		if err != nil {
+			report("%s: %v", prefix, err)
+			continue
+		}
+
+		name := prefix + ".secret"
+
+		if s.GetString(name) == "" {
+			report("%s: required", name)
+			name = prefix + ".previous-secrets"
+		}
+
+		for i, key := range c.Keys {
+			if i > 0 {
+				name = prefix + ".previous-secrets"
+			}
+
+			s.validateKey(report, name, key)
+		}
+
+		for _, size := range c.Sizes {
+			known := s.knownSize(nil, size)
+
+			// Clients may sign for any tenant
+			for _, name := range s.tenantNames() {
+				known = known || s.knownSize(&tenant{Name: name}, size)
+			}
+
+			if !known {
+				report("%s.sizes: %q is not a size", prefix, size)
+			}
+		}
+	}
+}
+
+// Reports a setting whose value is not one of those given
+func (s settings) oneOf(report func(string, ...interface{}), key string, values ...string) {
+	value := s.GetString(key)
+
+	for _, allowed := range values {
+		if value == allowed {
+			return
+		}
+	}
+
+	report("%s: %q is not one of %s", key, value, strings.Join(quoted(values), ", "))
+}
+
+func quoted(values []string) []string {
+	var list []string
+
+	for _, value := range values {
+		if value != "" {
+			list = append(list, strconv.Quote(value))
+		}
+	}
+
+	return list
+}
+
+// Checks that every tenant answers to hosts no other tenant lists, and its
+// keys and sizes the way the top-level ones are
+func (s settings) validateTenants(report func(string, ...interface{})) {
+	owners := map[string]string{}
+
+	for _, name := range s.tenantNames() {
+		prefix := "tenants." + name
+		hosts := s.GetStringSlice(prefix + ".hosts")
+
+		if len(hosts) == 0 {
+			report("%s.hosts: no hosts configured", prefix)
+		}
+
+		for _, host := range hosts {
+			host = strings.ToLower(host)
+
+			if owner, ok := owners[host]; ok {
+				report("%s.hosts: %q is already a host of tenants.%s", prefix, host, owner)
+			}
+
+			owners[host] = name
+		}
+
+		for _, key := range signing.KeysFrom(signing.DefaultAlgorithmFrom(s.Viper), s.GetString(prefix+".key"), s.Get(prefix+".keys")) {
+			s.validateKey(report, prefix+".key", key)
+		}
+
+		if s.IsSet(prefix + ".sizes") {
+			s.validateSizes(report, &tenant{Name: name})
+		}
+	}
+}
+
+// Checks that quotas are counts, or sizes for bytes, and that usage is
+// counted for them to apply
+func (s settings) validateQuotas(report func(string, ...interface{})) {
+	var accounts []string
+
+	for _, name := range s.tenantNames() {
+		accounts = append(accounts, "tenants."+name)
+	}
+
+	for name := range cast.ToStringMap(s.Get("clients")) {
+		accounts = append(accounts, "clients."+name)
+	}
+
+	sort.Strings(accounts)
+
+	for _, account := range accounts {
+		for _, metric := range usageMetrics {
+			key := account + ".quota." + metric
+
+			if !s.IsSet(key) {
+				continue
+			}
+
+			if !s.GetBool("usage.enabled") {
+				report("%s: requires usage.enabled", key)
+			}
+
+			if metric == "bytes" {
+				if s.GetSizeInBytes(key) == 0 && s.GetString(key) != "0" {
+					report("%s: %q is not a size such as 500GB", key, s.GetString(key))
+				}
+			} else if n, err := cast.ToInt64E(s.Get(key)); err != nil || n < 0 {
+				report("%s: %q is not a number", key, s.GetString(key))
+			}
+		}
+	}
+
+	switch s.GetInt("usage.quota-status") {
+	case 402, 429:
+	default:
+		report("usage.quota-status: %q is not one of 402, 429", s.GetString("usage.quota-status"))
+	}
+}
+
+func (s settings) validateBudgets(report func(string, ...interface{})) {
+	for _, period := range budgetPeriods {
+		for _, metric := range budgetMetrics {
+			key := "budgets." + period + "." + metric
+
+			if !s.IsSet(key) {
+				continue
+			}
+
+			if metric == "source-bytes" {
+				if s.GetSizeInBytes(key) == 0 && s.GetString(key) != "0" {
+					report("%s: %q is not a size such as 500GB", key, s.GetString(key))
+				}
+			} else if n, err := cast.ToFloat64E(s.Get(key)); err != nil || n < 0 {
+				report("%s: %q is not a number of seconds", key, s.GetString(key))
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// Starts each test from the defaults, with a size and a signing key so only
+// the settings it adds can fail
+func testConfig(t *testing.T, settings map[string]interface{}) {
+	t.Helper()
+	t.Setenv("GOTHUMB_CONFIG", filepath.Join(t.TempDir(), "config.toml"))
+	viper.Reset()
+	SetDefaults()
+	viper.Set("sizes.small", "100x100")
+	viper.Set("server.key", "0123456789abcdef")
+
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	publicKey := filepath.Join(t.TempDir(), "public.pem")
+
+	if err := ioutil.WriteFile(publicKey, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var mark bytes.Buffer
+	png.Encode(&mark, image.NewGray(image.Rect(0, 0, 2, 2)))
+	watermark := filepath.Join(t.TempDir(), "watermark.png")
+
+	if err := ioutil.WriteFile(watermark, mark.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		// Problems the error must list, or none for a valid config
+		problems []string
+	}{
+		{name: "defaults"},
+		{
+			name:     "short key",
+			settings: map[string]interface{}{"server.key": "short"},
+			problems: []string{"server.key: keys must be at least 16 characters"},
+		},
+		{
+			name:     "no key",
+			settings: map[string]interface{}{"server.key": ""},
+			problems: []string{"server.key: required"},
+		},
+		{
+			name:     "unknown algorithm",
+			settings: map[string]interface{}{"server.algorithm": "md5"},
+			problems: []string{`server.key: unknown algorithm "md5"`},
+		},
+		{
+			name:     "broken size",
+			settings: map[string]interface{}{"sizes.small": "100x"},
+			problems: []string{`sizes.small: "100x" is not WIDTHxHEIGHT`},
+		},
+		{
+			name:     "jwt without a secret",
+			settings: map[string]interface{}{"jwt.enabled": true},
+			problems: []string{"jwt.secret: required for HS256"},
+		},
+		{
+			name:     "jwt with a secret",
+			settings: map[string]interface{}{"jwt.enabled": true, "jwt.secret": "secret"},
+		},
+		{
+			name:     "jwt without a public key",
+			settings: map[string]interface{}{"jwt.enabled": true, "jwt.algorithm": "RS256"},
+			problems: []string{"jwt.public-key: required for RS256"},
+		},
+		{
+			name:     "jwt with a broken public key",
+			settings: map[string]interface{}{"jwt.enabled": true, "jwt.algorithm": "ES256", "jwt.public-key": publicKey},
+			problems: []string{"jwt.public-key: "},
+		},
+		{
+			name:     "jwt with an unknown algorithm",
+			settings: map[string]interface{}{"jwt.enabled": true, "jwt.algorithm": "none"},
+			problems: []string{`jwt.algorithm: unsupported JWT algorithm "none"`},
+		},
+		{
+			name:     "client without a secret",
+			settings: map[string]interface{}{"clients.app.sizes": []string{"small"}},
+			problems: []string{"clients.app.secret: required"},
+		},
+		{
+			name:     "client with a short previous secret",
+			settings: map[string]interface{}{"clients.app.secret": "0123456789abcdef", "clients.app.previous-secrets": []string{"short"}},
+			problems: []string{"clients.app.previous-secrets: keys must be at least 16 characters"},
+		},
+		{
+			name:     "client with an unknown size",
+			settings: map[string]interface{}{"clients.app.secret": "0123456789abcdef", "clients.app.sizes": []string{"huge"}},
+			problems: []string{`clients.app.sizes: "huge" is not a size`},
+		},
+		{
+			name:     "source max size",
+			settings: map[string]interface{}{"source.max-size": "20MB"},
+		},
+		{
+			name:     "source max size that is not a size",
+			settings: map[string]interface{}{"source.max-size": "ten"},
+			problems: []string{`source.max-size: "ten" is not a size such as 10MB`},
+		},
+		{
+			name:     "http3 without TLS",
+			settings: map[string]interface{}{"server.http3": true},
+			problems: []string{"server.http3: requires server.tls.cert or server.tls.autocert"},
+		},
+		{
+			name:     "http3 with a socket",
+			settings: map[string]interface{}{"server.http3": true, "server.tls.cert": "cert.pem", "server.tls.key": "key.pem", "server.socket": "/run/gothumb.sock"},
+			problems: []string{"server.http3: cannot be used with server.socket"},
+		},
+		{
+			name:     "access lists without ip-filter",
+			settings: map[string]interface{}{"server.middleware": []string{"request-id"}, "access.deny": []string{"10.0.0.0/8"}},
+			problems: []string{"access.deny: requires ip-filter in server.middleware"},
+		},
+		{
+			name:     "watermark action without a watermark",
+			settings: map[string]interface{}{"hotlink.action": "watermark"},
+			problems: []string{"hotlink.watermark: required to watermark thumbnails"},
+		},
+		{
+			name:     "watermarked size with a missing watermark",
+			settings: map[string]interface{}{"sizes.small": map[string]interface{}{"size": "100x100", "watermark": true}, "hotlink.watermark": "missing.png"},
+			problems: []string{"hotlink.watermark: open missing.png"},
+		},
+		{
+			name:     "watermark that is not an image",
+			settings: map[string]interface{}{"operations": []string{"watermark"}, "hotlink.watermark": publicKey},
+			problems: []string{"hotlink.watermark: image: unknown format"},
+		},
+		{
+			name:     "watermark action with a watermark",
+			settings: map[string]interface{}{"hotlink.action": "watermark", "hotlink.watermark": watermark},
+		},
+		{
+			name:     "access lists with ip-filter",
+			settings: map[string]interface{}{"server.middleware": []string{"ip-filter"}, "access.deny": []string{"10.0.0.0/8"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testConfig(t, test.settings)
+			err := Validate()
+
+			if len(test.problems) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("no error, want %q", test.problems)
+			}
+
+			for _, problem := range test.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("error %q does not list %q", err, problem)
+				}
+			}
+		})
+	}
+}
+
+func TestReloadKeepsConfigThatFailsValidation(t *testing.T) {
+	testConfig(t, nil)
+	file := os.Getenv("GOTHUMB_CONFIG")
+	write := func(config string) {
+		t.Helper()
+
+		if err := ioutil.WriteFile(file, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The key from testConfig is an override, which would hide the file's
+	viper.Reset()
+	SetDefaults()
+	write("[server]\nkey = \"0123456789abcdef\"\n[sizes]\nsmall = \"100x100\"\n")
+
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"short key", "[server]\nkey = \"short\"\n[sizes]\nsmall = \"100x100\"\n"},
+		{"missing key", "[sizes]\nsmall = \"100x100\"\n"},
+		{"broken size", "[server]\nkey = \"0123456789abcdef\"\n[sizes]\nsmall = \"100x\"\n"},
+		{"unknown operation", "operations = [\"nope\"]\n[server]\nkey = \"0123456789abcdef\"\n[sizes]\nsmall = \"100x100\"\n"},
+		{"syntax error", "[server\nkey = \"fedcba9876543210\"\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			write(test.config)
+			reloadConfig(file)
+
+			if key := viper.GetString("server.key"); key != "0123456789abcdef" {
+				t.Errorf("server.key = %q after a failed reload", key)
+			}
+
+			if size := viper.GetString("sizes.small"); size != "100x100" {
+				t.Errorf("sizes.small = %q after a failed reload", size)
+			}
+
+			if operations := viper.GetStringSlice("operations"); len(operations) > 0 {
+				t.Errorf("operations = %q after a failed reload", operations)
+			}
+		})
+	}
+
+	write("[server]\nkey = \"fedcba9876543210\"\n[sizes]\nsmall = \"200x200\"\n")
+	reloadConfig(file)
+
+	if key := viper.GetString("server.key"); key != "fedcba9876543210" {
+		t.Errorf("server.key = %q after a valid reload", key)
+	}
+
+	if size := viper.GetString("sizes.small"); size != "200x200" {
+		t.Errorf("sizes.small = %q after a valid reload", size)
+	}
+}
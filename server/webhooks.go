@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/joelchen/gothumb/internal/requestid"
+	"github.com/joelchen/gothumb/internal/secrets"
+	"github.com/spf13/viper"
+)
+
+var (
+	webhookQueue  = make(chan []byte, 1000)
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+type generationEvent struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	Source    string    `json:"source"`
+	Size      string    `json:"size"`
+	Path      string    `json:"path"`
+	Bytes     int64     `json:"bytes,omitempty"`
+	Duration  float64   `json:"duration_ms"`
+	Error     string    `json:"error,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// Queues a "generated" or "failed" event for webhooks.url once a thumbnail
+// has been processed
+func notifyGeneration(ctx context.Context, thumb thumbnail, path string, start time.Time, result *result, err error) {
+	if viper.GetString("webhooks.url") == "" {
+		return
+	}
+
+	event := &generationEvent{
+		Time:      time.Now().UTC(),
+		Event:     "generated",
+		Source:    thumb.Source,
+		Size:      thumb.Size,
+		Path:      path,
+		Duration:  float64(time.Since(start).Microseconds()) / 1000,
+		RequestID: requestid.From(ctx),
+	}
+
+	if err != nil {
+		event.Event = "failed"
+		event.Error = err.Error()
+	} else {
+		event.Bytes = result.ContentLength
+	}
+
+	queueWebhook(event)
+}
+
+// Queues an event for delivery to webhooks.url
+func queueWebhook(event interface{}) {
+	if viper.GetString("webhooks.url") == "" {
+		return
+	}
+
+	payload, _ := json.Marshal(event)
+	background.Add(1)
+
+	select {
+	case webhookQueue <- payload:
+	default:
+		background.Done()
+		logger.Warnf("webhooks: queue full, dropping event")
+	}
+}
+
+// Delivers queued events to webhooks.url one at a time
+func sendWebhooks() {
+	for payload := range webhookQueue {
+		if err := deliverWebhook(payload); err != nil {
+			logger.Errorf("webhooks: %v", err)
+		}
+
+		background.Done()
+	}
+}
+
+// Posts an event, retrying up to webhooks.retries times with a backoff that
+// doubles from a second. The body is signed with webhooks.secret, when
+// set, as a hex HMAC-SHA256 in X-Gothumb-Signature.
+func deliverWebhook(payload []byte) error {
+	backoff := time.Second
+	var err error
+
+	for attempt := 0; attempt <= viper.GetInt("webhooks.retries"); attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		if err = postWebhook(payload); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func postWebhook(payload []byte) error {
+	request, err := http.NewRequest("POST", viper.GetString("webhooks.url"), bytes.NewReader(payload))
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	if secret := secrets.Get("webhooks.secret"); secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		request.Header.Set("X-Gothumb-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := webhookClient.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected status code from webhook: %d", response.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package sign
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignLayout(t *testing.T) {
+	expires := time.Unix(2000000000, 0)
+
+	tests := []struct {
+		name    string
+		signer  Signer
+		size    string
+		options Options
+		prefix  string
+		query   url.Values
+	}{
+		{
+			name:   "query",
+			signer: Signer{Secret: "secret"},
+			size:   "small",
+			prefix: "/small/images/cat.jpg?",
+			query:  url.Values{"sig": nil},
+		},
+		{
+			name:    "query param, client, expires and download",
+			signer:  Signer{Secret: "secret", Param: "s", ClientID: "app", ClientParam: "client"},
+			size:    "small",
+			options: Options{Expires: expires, Download: "cat.jpg"},
+			prefix:  "/small/images/cat.jpg?",
+			query:   url.Values{"s": nil, "client": {"app"}, "expires": {"2000000000"}, "download": {"cat.jpg"}},
+		},
+		{
+			name:   "path",
+			signer: Signer{Secret: "secret", Mode: Path},
+			size:   "small",
+			prefix: "/",
+		},
+		{
+			name:   "thumbor",
+			signer: Signer{Secret: "secret", Mode: Thumbor},
+			size:   "300x200",
+			prefix: "/",
+		},
+		{
+			name:   "imgproxy",
+			signer: Signer{Secret: "736563726574", Salt: "73616c74", Mode: Imgproxy},
+			size:   "rs:fill:300:200",
+			prefix: "/",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signed, err := test.signer.Sign(test.size, "/images/cat.jpg", test.options)
+
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			if !strings.HasPrefix(signed, test.prefix) {
+				t.Errorf("%s does not start with %s", signed, test.prefix)
+			}
+
+			parsed, err := url.Parse(signed)
+
+			if err != nil {
+				t.Fatalf("%s: %v", signed, err)
+			}
+
+			for key, values := range test.query {
+				if got := parsed.Query()[key]; len(got) != 1 || values != nil && got[0] != values[0] {
+					t.Errorf("%s: %s = %v, want %v", signed, key, got, values)
+				}
+			}
+
+			again, _ := test.signer.Sign(test.size, "/images/cat.jpg", test.options)
+
+			if again != signed {
+				t.Errorf("signing twice gave %s and %s", signed, again)
+			}
+		})
+	}
+}
+
+func TestSignPathModes(t *testing.T) {
+	tests := []struct {
+		mode Mode
+		size string
+		// The path after the signature segment
+		rest string
+	}{
+		{Path, "small", "small/images/cat.jpg"},
+		{Thumbor, "300x200", "300x200/images/cat.jpg"},
+		// base64url of images/cat.jpg
+		{Imgproxy, "rs:fit:300:200", "rs:fit:300:200/aW1hZ2VzL2NhdC5qcGc"},
+	}
+
+	for _, test := range tests {
+		signer := Signer{Secret: "736563726574", Mode: test.mode}
+		signed, err := signer.Sign(test.size, "images/cat.jpg", Options{})
+
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(signed, "/"), "/", 2)
+
+		if len(parts) != 2 || parts[0] == "" || parts[1] != test.rest {
+			t.Errorf("mode %d: got %s, want /<signature>/%s", test.mode, signed, test.rest)
+		}
+	}
+}
+
+func TestMACErrors(t *testing.T) {
+	if _, err := MAC("md5", "secret", "/small/cat.jpg"); err == nil {
+		t.Error("MAC accepted an unknown algorithm")
+	}
+
+	if _, err := ImgproxyMAC("sha256", "not hex", "", "/rs:fit:1:1/x"); err == nil {
+		t.Error("ImgproxyMAC accepted a key that is not hex")
+	}
+
+	if _, err := ImgproxyMAC("sha256", "00", "not hex", "/rs:fit:1:1/x"); err == nil {
+		t.Error("ImgproxyMAC accepted a salt that is not hex")
+	}
+}
+
+func TestAlgorithmDefaults(t *testing.T) {
+	tests := []struct {
+		signer Signer
+		want   string
+	}{
+		{Signer{}, "sha3-256"},
+		{Signer{Mode: Path}, "sha3-256"},
+		{Signer{Mode: Thumbor}, "sha1"},
+		{Signer{Mode: Imgproxy}, "sha256"},
+		{Signer{Mode: Thumbor, Algorithm: "sha512"}, "sha512"},
+	}
+
+	for _, test := range tests {
+		if got := test.signer.algorithm(); got != test.want {
+			t.Errorf("mode %d, algorithm %q: got %s, want %s", test.signer.Mode, test.signer.Algorithm, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package signing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/joelchen/gothumb/internal/secrets"
+	"github.com/spf13/viper"
+)
+
+// RetiredKey is a key that no longer signs but is still accepted until its
+// grace period ends, so URLs embedded before a rotation keep working
+type RetiredKey struct {
+	Key
+	Until time.Time
+}
+
+var rotation = struct {
+	sync.Mutex
+	installed  *Key
+	configured string
+	seen       bool
+	retired    []RetiredKey
+}{}
+
+// Install makes the key the one URLs are signed with in place of
+// server.key until gothumb restarts, and keeps accepting the key it
+// replaces for the grace period
+func Install(key Key, grace time.Duration) {
+	if key.Algorithm == "" {
+		key.Algorithm = DefaultAlgorithm()
+	}
+
+	rotation.Lock()
+	defer rotation.Unlock()
+
+	if current, ok := primaryKey(); ok && current != key {
+		retire(current, grace)
+	}
+
+	// A key installed again signs rather than waiting out its grace period
+	for i, retired := range rotation.retired {
+		if retired.Key == key {
+			rotation.retired = append(rotation.retired[:i], rotation.retired[i+1:]...)
+			break
+		}
+	}
+
+	rotation.installed = &key
+}
+
+// Refresh notices server.key changing, as after a reload or a refresh of
+// the secret backends, and keeps accepting the key it replaced for the
+// grace period. The first call only records the key.
+func Refresh(grace time.Duration) {
+	rotation.Lock()
+	defer rotation.Unlock()
+
+	secret := secrets.Get("server.key")
+	previous, seen := rotation.configured, rotation.seen
+	rotation.configured, rotation.seen = secret, true
+
+	if !seen || previous == secret || previous == "" || rotation.installed != nil {
+		return
+	}
+
+	retire(Key{Secret: previous, Algorithm: DefaultAlgorithm()}, grace)
+}
+
+// Retired returns the keys still accepted after being replaced, with the
+// end of their grace periods
+func Retired() []RetiredKey {
+	rotation.Lock()
+	defer rotation.Unlock()
+
+	prune()
+	return append([]RetiredKey(nil), rotation.retired...)
+}
+
+// Returns the installed key, or server.key when none was installed
+func primaryKey() (Key, bool) {
+	if rotation.installed != nil {
+		return *rotation.installed, true
+	}
+
+	if secret := secrets.Get("server.key"); secret != "" {
+		return Key{Secret: secret, Algorithm: DefaultAlgorithm()}, true
+	}
+
+	return Key{}, false
+}
+
+// Returns the installed key followed by server.keys, or server.key and
+// server.keys, then the retired keys not already among them
+func currentKeys() []Key {
+	rotation.Lock()
+	defer rotation.Unlock()
+
+	var keys []Key
+
+	if rotation.installed != nil {
+		keys = append([]Key{*rotation.installed}, KeysFrom(DefaultAlgorithm(), "", viper.Get("server.keys"))...)
+	} else {
+		keys = KeysFrom(DefaultAlgorithm(), secrets.Get("server.key"), viper.Get("server.keys"))
+	}
+
+	prune()
+
+	for _, retired := range rotation.retired {
+		if !hasKey(keys, retired.Key) {
+			keys = append(keys, retired.Key)
+		}
+	}
+
+	return keys
+}
+
+func retire(key Key, grace time.Duration) {
+	if grace <= 0 {
+		return
+	}
+
+	for i, retired := range rotation.retired {
+		if retired.Key == key {
+			rotation.retired = append(rotation.retired[:i], rotation.retired[i+1:]...)
+			break
+		}
+	}
+
+	rotation.retired = append(rotation.retired, RetiredKey{Key: key, Until: time.Now().Add(grace)})
+}
+
+// Drops the keys whose grace period is over
+func prune() {
+	now := time.Now()
+	kept := rotation.retired[:0]
+
+	for _, retired := range rotation.retired {
+		if now.Before(retired.Until) {
+			kept = append(kept, retired)
+		}
+	}
+
+	rotation.retired = kept
+}
+
+func hasKey(keys []Key, key Key) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
+}
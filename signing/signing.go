@@ -0,0 +1,223 @@
+// Package signing verifies the signatures of requests against the keys in
+// the server config. URLs are signed with package sign.
+package signing
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joelchen/gothumb/internal/secrets"
+	"github.com/joelchen/gothumb/sign"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// ThumborMode reports whether URLs use thumbor's signature format
+func ThumborMode() bool {
+	return ThumborModeFrom(viper.GetViper())
+}
+
+// ThumborModeFrom reports whether a config, such as a reloaded one not yet
+// in use, has URLs use thumbor's signature format
+func ThumborModeFrom(v *viper.Viper) bool {
+	return v.GetString("server.signature-format") == "thumbor"
+}
+
+// ImgproxyMode reports whether URLs use imgproxy's syntax and signatures
+func ImgproxyMode() bool {
+	return ImgproxyModeFrom(viper.GetViper())
+}
+
+// ImgproxyModeFrom reports whether a config has URLs use imgproxy's syntax
+// and signatures
+func ImgproxyModeFrom(v *viper.Viper) bool {
+	return v.GetString("server.signature-format") == "imgproxy"
+}
+
+// InPath reports whether the signature is the first path segment
+func InPath() bool {
+	return viper.GetBool("server.signature-path") || ThumborMode() || ImgproxyMode()
+}
+
+// RequestSignature returns the signature supplied with the request and the
+// path it covers.
+// The signature may be given as a header, a query parameter or, when
+// server.signature-path is set, as the first path segment. Thumbor signs
+// the path after the signature without its leading slash. An expiry, if
+// present, is covered by the signature too, followed by a download
+// filename. pathSignature is the signature segment matched by the router.
+func RequestSignature(request *http.Request, pathSignature string) (sig, pathPart string) {
+	sig, pathPart = requestSignedPath(request, pathSignature)
+	query := request.URL.Query()
+
+	if expires := query.Get("expires"); expires != "" {
+		pathPart += "?expires=" + expires
+	}
+
+	if download := query.Get("download"); download != "" {
+		separator := "?"
+
+		if strings.Contains(pathPart, "?") {
+			separator = "&"
+		}
+
+		pathPart += separator + "download=" + url.QueryEscape(download)
+	}
+
+	return sig, pathPart
+}
+
+func requestSignedPath(request *http.Request, pathSignature string) (sig, pathPart string) {
+	pathPart = request.URL.EscapedPath()
+
+	if InPath() {
+		parts := strings.SplitN(pathPart, "/", 3)
+		pathPart = parts[len(parts)-1]
+
+		if !ThumborMode() {
+			pathPart = "/" + pathPart
+		}
+
+		return pathSignature, pathPart
+	}
+
+	if sig = request.Header.Get(viper.GetString("server.signature-header")); sig != "" {
+		return sig, pathPart
+	}
+
+	return request.URL.Query().Get(viper.GetString("server.signature-param")), pathPart
+}
+
+// Converts URL-safe and unpadded base64 to the standard encoding
+func normalizeSignature(sig string) string {
+	sig = strings.NewReplacer("-", "+", "_", "/", " ", "+").Replace(sig)
+
+	if n := len(sig) % 4; n != 0 {
+		sig += strings.Repeat("=", 4-n)
+	}
+
+	return sig
+}
+
+// Key is a secret and the HMAC algorithm it signs with
+type Key struct {
+	Secret    string
+	Algorithm string
+}
+
+// DefaultAlgorithm returns the algorithm for keys that do not name one
+func DefaultAlgorithm() string {
+	return DefaultAlgorithmFrom(viper.GetViper())
+}
+
+// DefaultAlgorithmFrom returns the algorithm a config gives keys that do
+// not name one
+func DefaultAlgorithmFrom(v *viper.Viper) string {
+	if algorithm := v.GetString("server.algorithm"); algorithm != "" {
+		return algorithm
+	}
+
+	switch {
+	case ThumborModeFrom(v):
+		return "sha1"
+	case ImgproxyModeFrom(v):
+		return "sha256"
+	}
+
+	return "sha3-256"
+}
+
+// Keys returns server.key, or the key installed in its place, followed by
+// any previous keys in server.keys and those still in their grace period,
+// all of which are accepted so secrets can be rotated without breaking old
+// URLs. Entries in server.keys are either plain secrets or tables with a
+// secret and its own algorithm.
+func Keys() []Key {
+	return currentKeys()
+}
+
+// KeysFrom returns the key, if any, followed by the entries of a list laid
+// out like server.keys, with the algorithm for those that do not name one
+func KeysFrom(algorithm, key string, entries interface{}) []Key {
+	var keys []Key
+
+	if key != "" {
+		keys = append(keys, Key{Secret: key, Algorithm: algorithm})
+	}
+
+	for _, entry := range cast.ToSlice(entries) {
+		if secret, ok := entry.(string); ok {
+			keys = append(keys, Key{Secret: secret, Algorithm: algorithm})
+			continue
+		}
+
+		fields := cast.ToStringMapString(entry)
+		key := Key{Secret: fields["secret"], Algorithm: fields["algorithm"]}
+
+		if key.Algorithm == "" {
+			key.Algorithm = algorithm
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// Keys and server.salt are hex encoded in imgproxy mode, as imgproxy's are
+func computeSignature(key Key, pathPart string) ([]byte, error) {
+	if ImgproxyMode() {
+		return sign.ImgproxyMAC(key.Algorithm, key.Secret, secrets.Get("server.salt"), pathPart)
+	}
+
+	return sign.MAC(key.Algorithm, key.Secret, pathPart)
+}
+
+// Validate checks that the base64 signature of the path matches one of the
+// keys
+func Validate(sig, pathPart string, keys []Key) error {
+	givenSig, err := base64.StdEncoding.DecodeString(normalizeSignature(sig))
+
+	if err != nil {
+		return fmt.Errorf("Signature mismatch")
+	}
+
+	for _, key := range keys {
+		expectedSig, err := computeSignature(key, pathPart)
+
+		if err != nil {
+			return err
+		}
+
+		if hmac.Equal(givenSig, expectedSig) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Signature mismatch")
+}
+
+// ValidateExpiry rejects expiry timestamps that have passed
+func ValidateExpiry(expires string) error {
+	if expires == "" {
+		return nil
+	}
+
+	timestamp, err := strconv.ParseInt(expires, 10, 64)
+
+	if err != nil {
+		return fmt.Errorf("Invalid expiry")
+	}
+
+	if time.Now().Unix() > timestamp {
+		return fmt.Errorf("Signature expired")
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package signing
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joelchen/gothumb/sign"
+	"github.com/spf13/viper"
+)
+
+const (
+	testSecret = "0123456789abcdef0123456789abcdef"
+	testSalt   = "fedcba9876543210"
+)
+
+// Configures the server side the way a Signer in the mode expects
+func setMode(t *testing.T, mode sign.Mode) {
+	t.Helper()
+	viper.Reset()
+	viper.Set("server.signature-param", "sig")
+	viper.Set("server.signature-header", "X-Signature")
+
+	switch mode {
+	case sign.Path:
+		viper.Set("server.signature-path", true)
+	case sign.Thumbor:
+		viper.Set("server.signature-format", "thumbor")
+	case sign.Imgproxy:
+		viper.Set("server.signature-format", "imgproxy")
+		viper.Set("server.salt", testSalt)
+	}
+}
+
+// Returns the first path segment, as the router matches it for signatures
+// given in the path
+func pathSignature(path string) string {
+	return strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+}
+
+func TestSignValidate(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		mode    sign.Mode
+		size    string
+		options sign.Options
+	}{
+		{name: "query", mode: sign.Query, size: "small"},
+		{name: "query expires", mode: sign.Query, size: "small", options: sign.Options{Expires: expires}},
+		{name: "query download", mode: sign.Query, size: "small", options: sign.Options{Download: "my cat.jpg"}},
+		{name: "query expires and download", mode: sign.Query, size: "small", options: sign.Options{Expires: expires, Download: "cat.jpg"}},
+		{name: "path", mode: sign.Path, size: "small"},
+		{name: "path expires and download", mode: sign.Path, size: "small", options: sign.Options{Expires: expires, Download: "cat.jpg"}},
+		{name: "thumbor", mode: sign.Thumbor, size: "300x200"},
+		{name: "thumbor expires", mode: sign.Thumbor, size: "300x200", options: sign.Options{Expires: expires}},
+		{name: "imgproxy", mode: sign.Imgproxy, size: "rs:fill:300:200"},
+		{name: "imgproxy download", mode: sign.Imgproxy, size: "rs:fit:300:200", options: sign.Options{Download: "cat.jpg"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setMode(t, test.mode)
+			signer := &sign.Signer{Secret: testSecret, Mode: test.mode, Salt: testSalt}
+			signed, err := signer.Sign(test.size, "images/cat.jpg", test.options)
+
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			request := httptest.NewRequest("GET", signed, nil)
+			sig, pathPart := RequestSignature(request, pathSignature(request.URL.Path))
+			keys := []Key{{Secret: testSecret, Algorithm: DefaultAlgorithm()}}
+
+			if err = Validate(sig, pathPart, keys); err != nil {
+				t.Errorf("%s: %v", signed, err)
+			}
+
+			if err = Validate(sig, pathPart, []Key{{Secret: strings.Repeat("f", 32), Algorithm: DefaultAlgorithm()}}); err == nil {
+				t.Errorf("%s: accepted with another key", signed)
+			}
+
+			if err = ValidateExpiry(request.URL.Query().Get("expires")); err != nil {
+				t.Errorf("%s: %v", signed, err)
+			}
+		})
+	}
+}
+
+func TestValidateTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   sign.Mode
+		size   string
+		tamper func(string) string
+	}{
+		{
+			name:   "query size",
+			mode:   sign.Query,
+			size:   "small",
+			tamper: func(signed string) string { return strings.Replace(signed, "/small/", "/large/", 1) },
+		},
+		{
+			name: "query expires",
+			mode: sign.Query,
+			size: "small",
+			tamper: func(signed string) string {
+				return strings.Replace(signed, "expires=", "expires=9", 1)
+			},
+		},
+		{
+			name:   "query download",
+			mode:   sign.Query,
+			size:   "small",
+			tamper: func(signed string) string { return strings.Replace(signed, "download=cat.jpg", "download=dog.jpg", 1) },
+		},
+		{
+			name:   "thumbor source",
+			mode:   sign.Thumbor,
+			size:   "300x200",
+			tamper: func(signed string) string { return strings.Replace(signed, "cat.jpg", "dog.jpg", 1) },
+		},
+		{
+			name:   "imgproxy options",
+			mode:   sign.Imgproxy,
+			size:   "rs:fill:300:200",
+			tamper: func(signed string) string { return strings.Replace(signed, "rs:fill", "rs:fit", 1) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setMode(t, test.mode)
+			signer := &sign.Signer{Secret: testSecret, Mode: test.mode, Salt: testSalt}
+			signed, err := signer.Sign(test.size, "images/cat.jpg", sign.Options{
+				Expires:  time.Now().Add(time.Hour),
+				Download: "cat.jpg",
+			})
+
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			request := httptest.NewRequest("GET", test.tamper(signed), nil)
+			sig, pathPart := RequestSignature(request, pathSignature(request.URL.Path))
+
+			if err = Validate(sig, pathPart, []Key{{Secret: testSecret, Algorithm: DefaultAlgorithm()}}); err == nil {
+				t.Errorf("%s: accepted", request.URL)
+			}
+		})
+	}
+}
+
+func TestValidateExpiry(t *testing.T) {
+	tests := []struct {
+		expires string
+		valid   bool
+	}{
+		{"", true},
+		{"9999999999", true},
+		{"1", false},
+		{"soon", false},
+	}
+
+	for _, test := range tests {
+		if err := ValidateExpiry(test.expires); (err == nil) != test.valid {
+			t.Errorf("ValidateExpiry(%q) = %v, want valid %v", test.expires, err, test.valid)
+		}
+	}
+}
+
+func TestRequestSignatureHeader(t *testing.T) {
+	setMode(t, sign.Query)
+	signed, err := (&sign.Signer{Secret: testSecret}).Sign("small", "images/cat.jpg", sign.Options{})
+
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	request := httptest.NewRequest("GET", signed, nil)
+	sig := request.URL.Query().Get("sig")
+	request.URL.RawQuery = ""
+	request.Header.Set("X-Signature", sig)
+	given, pathPart := RequestSignature(request, "")
+
+	if err = Validate(given, pathPart, []Key{{Secret: testSecret, Algorithm: DefaultAlgorithm()}}); err != nil {
+		t.Errorf("header signature: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package source
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ErrUnavailable is returned without contacting an origin whose circuit is
+// open after repeated failures
+var ErrUnavailable = fmt.Errorf("Source temporarily unavailable")
+
+// Failures of one origin host. After source.breaker.failures consecutive
+// failures the circuit opens and requests fail fast for
+// source.breaker.cooldown, after which a single probe is let through: its
+// success closes the circuit, its failure opens it again.
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+var breakers = struct {
+	sync.Mutex
+	byHost map[string]*breaker
+}{byHost: map[string]*breaker{}}
+
+func breakerHost(URL string) string {
+	parsed, err := url.Parse(URL)
+
+	if err != nil {
+		return ""
+	}
+
+	return parsed.Host
+}
+
+// Reports whether a request to the host may proceed
+func breakerAllow(host string) bool {
+	if viper.GetInt("source.breaker.failures") <= 0 {
+		return true
+	}
+
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	b := breakers.byHost[host]
+
+	if b == nil || b.openUntil.IsZero() {
+		return true
+	}
+
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+
+	b.probing = true
+	return true
+}
+
+// Records the outcome of a request to the host
+func breakerRecord(host string, failed bool) {
+	threshold := viper.GetInt("source.breaker.failures")
+
+	if threshold <= 0 {
+		return
+	}
+
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	b := breakers.byHost[host]
+
+	if !failed {
+		delete(breakers.byHost, host)
+		return
+	}
+
+	if b == nil {
+		b = &breaker{}
+		breakers.byHost[host] = b
+	}
+
+	b.failures++
+	b.probing = false
+
+	if b.failures >= threshold {
+		b.openUntil = time.Now().Add(viper.GetDuration("source.breaker.cooldown"))
+	}
+}
+
+// Lets another probe through after one was abandoned, without counting it
+// as a success or a failure
+func breakerAbandon(host string) {
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	if b := breakers.byHost[host]; b != nil {
+		b.probing = false
+	}
+}
@@ -0,0 +1,120 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/spf13/viper"
+	weighted "golang.org/x/sync/semaphore"
+)
+
+// Bytes of source images all requests together may hold, set by
+// memory.limit; nil means unlimited
+var (
+	memoryBudget *weighted.Weighted
+	memoryLimit  int64
+)
+
+// Read buffers reused across requests
+var sourceBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// Buffers grown past this are left to the garbage collector rather than
+// pinned in the pool
+const maxPooledBuffer = 8 * MB
+
+// Sized is a source body with the length its origin declared
+type Sized struct {
+	io.ReadCloser
+	Length int64
+}
+
+func setupMemoryBudget() {
+	if memoryLimit = int64(viper.GetSizeInBytes("memory.limit")); memoryLimit > 0 {
+		memoryBudget = weighted.NewWeighted(memoryLimit)
+	}
+}
+
+// Returns the most a request may read of a source: its declared length when
+// known, otherwise source.max-size or, failing that, memory.request-limit
+func sourceCeiling(body io.ReadCloser) int64 {
+	ceiling := int64(viper.GetSizeInBytes("source.max-size"))
+
+	if ceiling <= 0 {
+		ceiling = int64(viper.GetSizeInBytes("memory.request-limit"))
+	}
+
+	if sized, ok := body.(*Sized); ok && sized.Length > 0 && (ceiling <= 0 || sized.Length < ceiling) {
+		ceiling = sized.Length
+	}
+
+	return ceiling
+}
+
+// Read reads and closes a source body into a pooled buffer after reserving
+// its ceiling from the memory budget, waiting up to concurrency.max-wait for
+// room. It fails with ErrTooLarge once the body exceeds the ceiling. The
+// returned function hands the memory back and must be called once the bytes
+// are no longer used.
+func Read(ctx context.Context, body io.ReadCloser) ([]byte, func(), error) {
+	defer body.Close()
+	ceiling := sourceCeiling(body)
+	reserved := int64(0)
+
+	if memoryBudget != nil && ceiling > memoryLimit {
+		return nil, nil, ErrTooLarge
+	}
+
+	if memoryBudget != nil && ceiling > 0 {
+		wait, cancel := context.WithTimeout(ctx, viper.GetDuration("concurrency.max-wait"))
+		err := memoryBudget.Acquire(wait, ceiling)
+		cancel()
+
+		if err == context.DeadlineExceeded && ctx.Err() == nil {
+			return nil, nil, ErrBusy
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		reserved = ceiling
+	}
+
+	buf := sourceBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	release := func() {
+		if buf.Cap() <= maxPooledBuffer {
+			sourceBuffers.Put(buf)
+		}
+
+		if reserved > 0 {
+			memoryBudget.Release(reserved)
+		}
+	}
+
+	if sized, ok := body.(*Sized); ok && sized.Length == ceiling {
+		buf.Grow(int(ceiling))
+	}
+
+	var reader io.Reader = body
+
+	if ceiling > 0 {
+		reader = io.LimitReader(body, ceiling+1)
+	}
+
+	_, err := buf.ReadFrom(reader)
+
+	if err == nil && ceiling > 0 && int64(buf.Len()) > ceiling {
+		err = ErrTooLarge
+	}
+
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return buf.Bytes(), release, nil
+}
@@ -0,0 +1,220 @@
+// Package source fetches original images over HTTP and reads them into
+// memory within a shared budget
+package source
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/joelchen/gothumb/internal/requestid"
+	"github.com/spf13/viper"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Filtered by log.levels.source
+var logger = logging.New("source")
+
+// Size in bytes
+const (
+	_  = iota
+	KB = 1 << (10 * iota)
+	MB
+)
+
+var (
+	// ErrNotModified is returned by Fetch when the cached validators still match
+	ErrNotModified = fmt.Errorf("Source not modified")
+	// ErrNotFound is returned when the origin has no such image
+	ErrNotFound = fmt.Errorf("Source not found")
+	// ErrTooLarge is returned for sources over source.max-size or the memory
+	// budget
+	ErrTooLarge = fmt.Errorf("Source too large")
+	// ErrBusy is returned when the memory budget had no room in time
+	ErrBusy = fmt.Errorf("Too many concurrent resizes")
+)
+
+var httpClient = http.DefaultClient
+
+// Validators of a remote original, used for conditional re-fetching
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// Setup builds the HTTP client for origins and the memory budget from the
+// config
+func Setup() {
+	httpClient = newHTTPClient()
+	setupMemoryBudget()
+}
+
+func newHTTPClient() *http.Client {
+	transport := newTransport()
+	proxy := viper.GetString("source.proxy")
+
+	if proxy == "" {
+		return &http.Client{Transport: transport}
+	}
+
+	config := &httpproxy.Config{
+		HTTPProxy:  proxy,
+		HTTPSProxy: proxy,
+		NoProxy:    strings.Join(viper.GetStringSlice("source.no-proxy"), ","),
+	}
+
+	proxyFunc := config.ProxyFunc()
+	transport.Proxy = func(request *http.Request) (*url.URL, error) {
+		return proxyFunc(request.URL)
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// Builds the transport for origins from the settings under
+// source.transport, which default to those of http.DefaultTransport apart
+// from keeping more idle connections per host
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   viper.GetDuration("source.transport.dial-timeout"),
+		KeepAlive: viper.GetDuration("source.transport.keep-alive"),
+	}
+
+	transport.DialContext = dialer.DialContext
+	transport.MaxIdleConns = viper.GetInt("source.transport.max-idle-conns")
+	transport.MaxIdleConnsPerHost = viper.GetInt("source.transport.max-idle-conns-per-host")
+	transport.MaxConnsPerHost = viper.GetInt("source.transport.max-conns-per-host")
+	transport.IdleConnTimeout = viper.GetDuration("source.transport.idle-conn-timeout")
+	transport.TLSHandshakeTimeout = viper.GetDuration("source.transport.tls-handshake-timeout")
+	transport.ResponseHeaderTimeout = viper.GetDuration("source.transport.response-header-timeout")
+	transport.DisableKeepAlives = !viper.GetBool("source.transport.keep-alives")
+
+	if !viper.GetBool("source.transport.http2") {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	return transport
+}
+
+// FetchContext bounds the context of a source fetch, reading its body
+// included, by deadlines.fetch when it is set. The returned function must be
+// called once the fetch is over, which closing a body from CancelOnClose
+// does.
+func FetchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := viper.GetDuration("deadlines.fetch"); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// CancelOnClose returns the body calling cancel once it is closed
+func CancelOnClose(body io.ReadCloser, cancel context.CancelFunc) io.ReadCloser {
+	return &cancelingBody{body, cancel}
+}
+
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// Fetch requests an original, conditionally when validators of a cached
+// copy are given, and returns its body along with its current validators.
+// Fetching and reading the body take at most deadlines.fetch.
+func Fetch(ctx context.Context, URL string, cached Validators) (io.ReadCloser, Validators, error) {
+	request, err := http.NewRequest("GET", URL, nil)
+
+	if err != nil {
+		return nil, cached, err
+	}
+
+	host := breakerHost(URL)
+
+	if !breakerAllow(host) {
+		logger.Ctx(ctx).Debug("origin failing, not fetching", "host", host, "url", URL)
+		return nil, cached, ErrUnavailable
+	}
+
+	ctx, cancel := FetchContext(ctx)
+	body, validators, err := fetch(ctx, request, URL, host, cached)
+
+	if err != nil {
+		cancel()
+		return nil, validators, err
+	}
+
+	body.ReadCloser = CancelOnClose(body.ReadCloser, cancel)
+	return body, validators, nil
+}
+
+func fetch(ctx context.Context, request *http.Request, URL, host string, cached Validators) (*Sized, Validators, error) {
+	request = request.WithContext(ctx)
+	request.Header.Set("X-Request-ID", requestid.From(ctx))
+
+	if cached.ETag != "" {
+		request.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	if cached.LastModified != "" {
+		request.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+
+	start := time.Now()
+	response, err := httpClient.Do(request)
+
+	if err != nil {
+		logger.Ctx(ctx).Debug("fetch failed", "url", URL, "error", err)
+	} else {
+		logger.Ctx(ctx).Debug("fetched", "url", URL, "status", response.StatusCode, "duration", time.Since(start))
+	}
+
+	// Requests cut short by the caller say nothing about the origin
+	if ctx.Err() != nil {
+		breakerAbandon(host)
+	} else {
+		breakerRecord(host, err != nil || response.StatusCode >= 500)
+	}
+
+	if err != nil {
+		return nil, cached, err
+	}
+
+	if response.StatusCode == 304 {
+		response.Body.Close()
+		return nil, cached, ErrNotModified
+	}
+
+	if response.StatusCode == 404 || response.StatusCode == 410 {
+		response.Body.Close()
+		return nil, cached, ErrNotFound
+	}
+
+	if maxSize := int64(viper.GetSizeInBytes("source.max-size")); maxSize > 0 && response.ContentLength > maxSize {
+		response.Body.Close()
+		return nil, cached, ErrTooLarge
+	}
+
+	if response.StatusCode != 200 {
+		response.Body.Close()
+		return nil, cached, fmt.Errorf("Unexpected status code from source: %d", response.StatusCode)
+	}
+
+	return &Sized{response.Body, response.ContentLength}, Validators{
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+	}, nil
+}
@@ -0,0 +1,111 @@
+// Package storage holds the S3 client for the bucket thumbnails are cached
+// in
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	awsrequest "github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/joelchen/gothumb/internal/requestid"
+	"github.com/joelchen/gothumb/internal/secrets"
+	"github.com/spf13/viper"
+)
+
+// Filtered by log.levels.storage
+var logger = logging.New("storage")
+
+// S3 client shared by all requests so connections to the bucket are reused
+var storage = struct {
+	sync.RWMutex
+	bucket string
+	svc    *s3.S3
+}{}
+
+// Setup builds the shared S3 client for s3.bucket, if set, and rebuilds it
+// whenever refreshed secrets change. Requests already holding the previous
+// client finish with it.
+func Setup() error {
+	bucket := viper.GetString("s3.bucket")
+
+	if bucket == "" {
+		return nil
+	}
+
+	if err := connect(bucket); err != nil {
+		return err
+	}
+
+	secrets.OnChange(func() {
+		if err := connect(bucket); err != nil {
+			logger.Errorf("%v", err)
+		}
+	})
+
+	return nil
+}
+
+func connect(bucket string) error {
+	sess, err := session.NewSession(Config())
+
+	if err != nil {
+		return err
+	}
+
+	storage.Lock()
+	storage.bucket = bucket
+	storage.svc = s3.New(sess)
+	storage.Unlock()
+
+	return nil
+}
+
+// Bucket returns the cache bucket, empty when results are not cached
+func Bucket() string {
+	storage.RLock()
+	defer storage.RUnlock()
+
+	return storage.bucket
+}
+
+// Service returns the current S3 client
+func Service() *s3.S3 {
+	storage.RLock()
+	defer storage.RUnlock()
+
+	return storage.svc
+}
+
+// Config returns the AWS config for the bucket's region and credentials.
+// With log.levels.storage at debug, requests, retries and errors are
+// logged, without bodies.
+func Config() *aws.Config {
+	config := &aws.Config{
+		Region: aws.String(viper.GetString("s3.region")),
+		Credentials: credentials.NewStaticCredentials(
+			secrets.Get("s3.access-key-id"),
+			secrets.Get("s3.secret-access-key"),
+			"",
+		),
+	}
+
+	if logger.Enabled(logging.Debug) {
+		config.LogLevel = aws.LogLevel(aws.LogDebugWithRequestRetries | aws.LogDebugWithRequestErrors)
+		config.Logger = aws.LoggerFunc(func(args ...interface{}) {
+			logger.Debug(fmt.Sprint(args...))
+		})
+	}
+
+	return config
+}
+
+// RequestID sends the ID of the request being served along with S3 calls
+func RequestID(ctx context.Context) awsrequest.Option {
+	return awsrequest.WithSetRequestHeaders(map[string]string{"X-Request-ID": requestid.From(ctx)})
+}
@@ -0,0 +1,4 @@
+// Package thumbpb holds the gRPC service generated from proto/gothumb.proto
+package thumbpb
+
+//go:generate protoc -I ../proto --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative gothumb.proto
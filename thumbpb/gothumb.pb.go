@@ -0,0 +1,635 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: gothumb.proto
+
+package thumbpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ResizeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Key in the bucket or URL of the original
+	Source string `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Size   string `protobuf:"bytes,2,opt,name=size,proto3" json:"size,omitempty"`
+	// Output format such as "webp", or empty to keep the source's format
+	Format        string `protobuf:"bytes,3,opt,name=format,proto3" json:"format,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResizeRequest) Reset() {
+	*x = ResizeRequest{}
+	mi := &file_gothumb_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResizeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResizeRequest) ProtoMessage() {}
+
+func (x *ResizeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gothumb_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResizeRequest.ProtoReflect.Descriptor instead.
+func (*ResizeRequest) Descriptor() ([]byte, []int) {
+	return file_gothumb_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ResizeRequest) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *ResizeRequest) GetSize() string {
+	if x != nil {
+		return x.Size
+	}
+	return ""
+}
+
+func (x *ResizeRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+type ResizeChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ContentType   string                 `protobuf:"bytes,1,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	ContentLength int64                  `protobuf:"varint,2,opt,name=content_length,json=contentLength,proto3" json:"content_length,omitempty"`
+	Etag          string                 `protobuf:"bytes,3,opt,name=etag,proto3" json:"etag,omitempty"`
+	Data          []byte                 `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResizeChunk) Reset() {
+	*x = ResizeChunk{}
+	mi := &file_gothumb_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResizeChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResizeChunk) ProtoMessage() {}
+
+func (x *ResizeChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_gothumb_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResizeChunk.ProtoReflect.Descriptor instead.
+func (*ResizeChunk) Descriptor() ([]byte, []int) {
+	return file_gothumb_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ResizeChunk) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *ResizeChunk) GetContentLength() int64 {
+	if x != nil {
+		return x.ContentLength
+	}
+	return 0
+}
+
+func (x *ResizeChunk) GetEtag() string {
+	if x != nil {
+		return x.Etag
+	}
+	return ""
+}
+
+func (x *ResizeChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type GetInfoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetInfoRequest) Reset() {
+	*x = GetInfoRequest{}
+	mi := &file_gothumb_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetInfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetInfoRequest) ProtoMessage() {}
+
+func (x *GetInfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gothumb_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetInfoRequest.ProtoReflect.Descriptor instead.
+func (*GetInfoRequest) Descriptor() ([]byte, []int) {
+	return file_gothumb_proto_rawDescGZIP(), []int{2}
+}
+
+type GetInfoResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Build         map[string]string      `protobuf:"bytes,1,rep,name=build,proto3" json:"build,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Sizes         []*Size                `protobuf:"bytes,2,rep,name=sizes,proto3" json:"sizes,omitempty"`
+	Formats       []string               `protobuf:"bytes,3,rep,name=formats,proto3" json:"formats,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetInfoResponse) Reset() {
+	*x = GetInfoResponse{}
+	mi := &file_gothumb_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetInfoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetInfoResponse) ProtoMessage() {}
+
+func (x *GetInfoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gothumb_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetInfoResponse.ProtoReflect.Descriptor instead.
+func (*GetInfoResponse) Descriptor() ([]byte, []int) {
+	return file_gothumb_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetInfoResponse) GetBuild() map[string]string {
+	if x != nil {
+		return x.Build
+	}
+	return nil
+}
+
+func (x *GetInfoResponse) GetSizes() []*Size {
+	if x != nil {
+		return x.Sizes
+	}
+	return nil
+}
+
+func (x *GetInfoResponse) GetFormats() []string {
+	if x != nil {
+		return x.Formats
+	}
+	return nil
+}
+
+type Size struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Width         int32                  `protobuf:"varint,2,opt,name=width,proto3" json:"width,omitempty"`
+	Height        int32                  `protobuf:"varint,3,opt,name=height,proto3" json:"height,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Size) Reset() {
+	*x = Size{}
+	mi := &file_gothumb_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Size) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Size) ProtoMessage() {}
+
+func (x *Size) ProtoReflect() protoreflect.Message {
+	mi := &file_gothumb_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Size.ProtoReflect.Descriptor instead.
+func (*Size) Descriptor() ([]byte, []int) {
+	return file_gothumb_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Size) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Size) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *Size) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+type PurgeRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Source string                 `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	// Every size when empty
+	Sizes         []string `protobuf:"bytes,2,rep,name=sizes,proto3" json:"sizes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PurgeRequest) Reset() {
+	*x = PurgeRequest{}
+	mi := &file_gothumb_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PurgeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PurgeRequest) ProtoMessage() {}
+
+func (x *PurgeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gothumb_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PurgeRequest.ProtoReflect.Descriptor instead.
+func (*PurgeRequest) Descriptor() ([]byte, []int) {
+	return file_gothumb_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PurgeRequest) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *PurgeRequest) GetSizes() []string {
+	if x != nil {
+		return x.Sizes
+	}
+	return nil
+}
+
+type PurgeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Purged        []string               `protobuf:"bytes,1,rep,name=purged,proto3" json:"purged,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PurgeResponse) Reset() {
+	*x = PurgeResponse{}
+	mi := &file_gothumb_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PurgeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PurgeResponse) ProtoMessage() {}
+
+func (x *PurgeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gothumb_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PurgeResponse.ProtoReflect.Descriptor instead.
+func (*PurgeResponse) Descriptor() ([]byte, []int) {
+	return file_gothumb_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *PurgeResponse) GetPurged() []string {
+	if x != nil {
+		return x.Purged
+	}
+	return nil
+}
+
+type PregenerateRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Sources []string               `protobuf:"bytes,1,rep,name=sources,proto3" json:"sources,omitempty"`
+	// Every configured size when empty, or those of a token's client limited
+	// to some
+	Sizes []string `protobuf:"bytes,2,rep,name=sizes,proto3" json:"sizes,omitempty"`
+	// Regenerate sizes that are already cached
+	Force         bool `protobuf:"varint,3,opt,name=force,proto3" json:"force,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PregenerateRequest) Reset() {
+	*x = PregenerateRequest{}
+	mi := &file_gothumb_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PregenerateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PregenerateRequest) ProtoMessage() {}
+
+func (x *PregenerateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gothumb_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PregenerateRequest.ProtoReflect.Descriptor instead.
+func (*PregenerateRequest) Descriptor() ([]byte, []int) {
+	return file_gothumb_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *PregenerateRequest) GetSources() []string {
+	if x != nil {
+		return x.Sources
+	}
+	return nil
+}
+
+func (x *PregenerateRequest) GetSizes() []string {
+	if x != nil {
+		return x.Sizes
+	}
+	return nil
+}
+
+func (x *PregenerateRequest) GetForce() bool {
+	if x != nil {
+		return x.Force
+	}
+	return false
+}
+
+type PregenerateResult struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Source string                 `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	// Empty when every size was generated
+	Error         string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PregenerateResult) Reset() {
+	*x = PregenerateResult{}
+	mi := &file_gothumb_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PregenerateResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PregenerateResult) ProtoMessage() {}
+
+func (x *PregenerateResult) ProtoReflect() protoreflect.Message {
+	mi := &file_gothumb_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PregenerateResult.ProtoReflect.Descriptor instead.
+func (*PregenerateResult) Descriptor() ([]byte, []int) {
+	return file_gothumb_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *PregenerateResult) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *PregenerateResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_gothumb_proto protoreflect.FileDescriptor
+
+const file_gothumb_proto_rawDesc = "" +
+	"\n" +
+	"\rgothumb.proto\x12\n" +
+	"gothumb.v1\"S\n" +
+	"\rResizeRequest\x12\x16\n" +
+	"\x06source\x18\x01 \x01(\tR\x06source\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\tR\x04size\x12\x16\n" +
+	"\x06format\x18\x03 \x01(\tR\x06format\"\x7f\n" +
+	"\vResizeChunk\x12!\n" +
+	"\fcontent_type\x18\x01 \x01(\tR\vcontentType\x12%\n" +
+	"\x0econtent_length\x18\x02 \x01(\x03R\rcontentLength\x12\x12\n" +
+	"\x04etag\x18\x03 \x01(\tR\x04etag\x12\x12\n" +
+	"\x04data\x18\x04 \x01(\fR\x04data\"\x10\n" +
+	"\x0eGetInfoRequest\"\xcb\x01\n" +
+	"\x0fGetInfoResponse\x12<\n" +
+	"\x05build\x18\x01 \x03(\v2&.gothumb.v1.GetInfoResponse.BuildEntryR\x05build\x12&\n" +
+	"\x05sizes\x18\x02 \x03(\v2\x10.gothumb.v1.SizeR\x05sizes\x12\x18\n" +
+	"\aformats\x18\x03 \x03(\tR\aformats\x1a8\n" +
+	"\n" +
+	"BuildEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"H\n" +
+	"\x04Size\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05width\x18\x02 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x03 \x01(\x05R\x06height\"<\n" +
+	"\fPurgeRequest\x12\x16\n" +
+	"\x06source\x18\x01 \x01(\tR\x06source\x12\x14\n" +
+	"\x05sizes\x18\x02 \x03(\tR\x05sizes\"'\n" +
+	"\rPurgeResponse\x12\x16\n" +
+	"\x06purged\x18\x01 \x03(\tR\x06purged\"Z\n" +
+	"\x12PregenerateRequest\x12\x18\n" +
+	"\asources\x18\x01 \x03(\tR\asources\x12\x14\n" +
+	"\x05sizes\x18\x02 \x03(\tR\x05sizes\x12\x14\n" +
+	"\x05force\x18\x03 \x01(\bR\x05force\"A\n" +
+	"\x11PregenerateResult\x12\x16\n" +
+	"\x06source\x18\x01 \x01(\tR\x06source\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error2\x9e\x02\n" +
+	"\n" +
+	"Thumbnails\x12>\n" +
+	"\x06Resize\x12\x19.gothumb.v1.ResizeRequest\x1a\x17.gothumb.v1.ResizeChunk0\x01\x12B\n" +
+	"\aGetInfo\x12\x1a.gothumb.v1.GetInfoRequest\x1a\x1b.gothumb.v1.GetInfoResponse\x12<\n" +
+	"\x05Purge\x12\x18.gothumb.v1.PurgeRequest\x1a\x19.gothumb.v1.PurgeResponse\x12N\n" +
+	"\vPregenerate\x12\x1e.gothumb.v1.PregenerateRequest\x1a\x1d.gothumb.v1.PregenerateResult0\x01B%Z#github.com/joelchen/gothumb/thumbpbb\x06proto3"
+
+var (
+	file_gothumb_proto_rawDescOnce sync.Once
+	file_gothumb_proto_rawDescData []byte
+)
+
+func file_gothumb_proto_rawDescGZIP() []byte {
+	file_gothumb_proto_rawDescOnce.Do(func() {
+		file_gothumb_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_gothumb_proto_rawDesc), len(file_gothumb_proto_rawDesc)))
+	})
+	return file_gothumb_proto_rawDescData
+}
+
+var file_gothumb_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_gothumb_proto_goTypes = []any{
+	(*ResizeRequest)(nil),      // 0: gothumb.v1.ResizeRequest
+	(*ResizeChunk)(nil),        // 1: gothumb.v1.ResizeChunk
+	(*GetInfoRequest)(nil),     // 2: gothumb.v1.GetInfoRequest
+	(*GetInfoResponse)(nil),    // 3: gothumb.v1.GetInfoResponse
+	(*Size)(nil),               // 4: gothumb.v1.Size
+	(*PurgeRequest)(nil),       // 5: gothumb.v1.PurgeRequest
+	(*PurgeResponse)(nil),      // 6: gothumb.v1.PurgeResponse
+	(*PregenerateRequest)(nil), // 7: gothumb.v1.PregenerateRequest
+	(*PregenerateResult)(nil),  // 8: gothumb.v1.PregenerateResult
+	nil,                        // 9: gothumb.v1.GetInfoResponse.BuildEntry
+}
+var file_gothumb_proto_depIdxs = []int32{
+	9, // 0: gothumb.v1.GetInfoResponse.build:type_name -> gothumb.v1.GetInfoResponse.BuildEntry
+	4, // 1: gothumb.v1.GetInfoResponse.sizes:type_name -> gothumb.v1.Size
+	0, // 2: gothumb.v1.Thumbnails.Resize:input_type -> gothumb.v1.ResizeRequest
+	2, // 3: gothumb.v1.Thumbnails.GetInfo:input_type -> gothumb.v1.GetInfoRequest
+	5, // 4: gothumb.v1.Thumbnails.Purge:input_type -> gothumb.v1.PurgeRequest
+	7, // 5: gothumb.v1.Thumbnails.Pregenerate:input_type -> gothumb.v1.PregenerateRequest
+	1, // 6: gothumb.v1.Thumbnails.Resize:output_type -> gothumb.v1.ResizeChunk
+	3, // 7: gothumb.v1.Thumbnails.GetInfo:output_type -> gothumb.v1.GetInfoResponse
+	6, // 8: gothumb.v1.Thumbnails.Purge:output_type -> gothumb.v1.PurgeResponse
+	8, // 9: gothumb.v1.Thumbnails.Pregenerate:output_type -> gothumb.v1.PregenerateResult
+	6, // [6:10] is the sub-list for method output_type
+	2, // [2:6] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_gothumb_proto_init() }
+func file_gothumb_proto_init() {
+	if File_gothumb_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_gothumb_proto_rawDesc), len(file_gothumb_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_gothumb_proto_goTypes,
+		DependencyIndexes: file_gothumb_proto_depIdxs,
+		MessageInfos:      file_gothumb_proto_msgTypes,
+	}.Build()
+	File_gothumb_proto = out.File
+	file_gothumb_proto_goTypes = nil
+	file_gothumb_proto_depIdxs = nil
+}
@@ -0,0 +1,258 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: gothumb.proto
+
+package thumbpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Thumbnails_Resize_FullMethodName      = "/gothumb.v1.Thumbnails/Resize"
+	Thumbnails_GetInfo_FullMethodName     = "/gothumb.v1.Thumbnails/GetInfo"
+	Thumbnails_Purge_FullMethodName       = "/gothumb.v1.Thumbnails/Purge"
+	Thumbnails_Pregenerate_FullMethodName = "/gothumb.v1.Thumbnails/Pregenerate"
+)
+
+// ThumbnailsClient is the client API for Thumbnails service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Thumbnail service for internal callers, served on grpc.address
+type ThumbnailsClient interface {
+	// Streams a thumbnail, from the cache when it is there. The first chunk
+	// carries the metadata.
+	Resize(ctx context.Context, in *ResizeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ResizeChunk], error)
+	// Describes the sizes, formats and build of the server
+	GetInfo(ctx context.Context, in *GetInfoRequest, opts ...grpc.CallOption) (*GetInfoResponse, error)
+	// Deletes cached thumbnails of a source
+	Purge(ctx context.Context, in *PurgeRequest, opts ...grpc.CallOption) (*PurgeResponse, error)
+	// Renders sizes of sources into the cache, streaming one result per
+	// source as it finishes
+	Pregenerate(ctx context.Context, in *PregenerateRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PregenerateResult], error)
+}
+
+type thumbnailsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewThumbnailsClient(cc grpc.ClientConnInterface) ThumbnailsClient {
+	return &thumbnailsClient{cc}
+}
+
+func (c *thumbnailsClient) Resize(ctx context.Context, in *ResizeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ResizeChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Thumbnails_ServiceDesc.Streams[0], Thumbnails_Resize_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ResizeRequest, ResizeChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Thumbnails_ResizeClient = grpc.ServerStreamingClient[ResizeChunk]
+
+func (c *thumbnailsClient) GetInfo(ctx context.Context, in *GetInfoRequest, opts ...grpc.CallOption) (*GetInfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetInfoResponse)
+	err := c.cc.Invoke(ctx, Thumbnails_GetInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *thumbnailsClient) Purge(ctx context.Context, in *PurgeRequest, opts ...grpc.CallOption) (*PurgeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PurgeResponse)
+	err := c.cc.Invoke(ctx, Thumbnails_Purge_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *thumbnailsClient) Pregenerate(ctx context.Context, in *PregenerateRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PregenerateResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Thumbnails_ServiceDesc.Streams[1], Thumbnails_Pregenerate_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PregenerateRequest, PregenerateResult]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Thumbnails_PregenerateClient = grpc.ServerStreamingClient[PregenerateResult]
+
+// ThumbnailsServer is the server API for Thumbnails service.
+// All implementations must embed UnimplementedThumbnailsServer
+// for forward compatibility.
+//
+// Thumbnail service for internal callers, served on grpc.address
+type ThumbnailsServer interface {
+	// Streams a thumbnail, from the cache when it is there. The first chunk
+	// carries the metadata.
+	Resize(*ResizeRequest, grpc.ServerStreamingServer[ResizeChunk]) error
+	// Describes the sizes, formats and build of the server
+	GetInfo(context.Context, *GetInfoRequest) (*GetInfoResponse, error)
+	// Deletes cached thumbnails of a source
+	Purge(context.Context, *PurgeRequest) (*PurgeResponse, error)
+	// Renders sizes of sources into the cache, streaming one result per
+	// source as it finishes
+	Pregenerate(*PregenerateRequest, grpc.ServerStreamingServer[PregenerateResult]) error
+	mustEmbedUnimplementedThumbnailsServer()
+}
+
+// UnimplementedThumbnailsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedThumbnailsServer struct{}
+
+func (UnimplementedThumbnailsServer) Resize(*ResizeRequest, grpc.ServerStreamingServer[ResizeChunk]) error {
+	return status.Error(codes.Unimplemented, "method Resize not implemented")
+}
+func (UnimplementedThumbnailsServer) GetInfo(context.Context, *GetInfoRequest) (*GetInfoResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetInfo not implemented")
+}
+func (UnimplementedThumbnailsServer) Purge(context.Context, *PurgeRequest) (*PurgeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Purge not implemented")
+}
+func (UnimplementedThumbnailsServer) Pregenerate(*PregenerateRequest, grpc.ServerStreamingServer[PregenerateResult]) error {
+	return status.Error(codes.Unimplemented, "method Pregenerate not implemented")
+}
+func (UnimplementedThumbnailsServer) mustEmbedUnimplementedThumbnailsServer() {}
+func (UnimplementedThumbnailsServer) testEmbeddedByValue()                    {}
+
+// UnsafeThumbnailsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ThumbnailsServer will
+// result in compilation errors.
+type UnsafeThumbnailsServer interface {
+	mustEmbedUnimplementedThumbnailsServer()
+}
+
+func RegisterThumbnailsServer(s grpc.ServiceRegistrar, srv ThumbnailsServer) {
+	// If the following call panics, it indicates UnimplementedThumbnailsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Thumbnails_ServiceDesc, srv)
+}
+
+func _Thumbnails_Resize_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ResizeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ThumbnailsServer).Resize(m, &grpc.GenericServerStream[ResizeRequest, ResizeChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Thumbnails_ResizeServer = grpc.ServerStreamingServer[ResizeChunk]
+
+func _Thumbnails_GetInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ThumbnailsServer).GetInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Thumbnails_GetInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ThumbnailsServer).GetInfo(ctx, req.(*GetInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Thumbnails_Purge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ThumbnailsServer).Purge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Thumbnails_Purge_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ThumbnailsServer).Purge(ctx, req.(*PurgeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Thumbnails_Pregenerate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PregenerateRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ThumbnailsServer).Pregenerate(m, &grpc.GenericServerStream[PregenerateRequest, PregenerateResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Thumbnails_PregenerateServer = grpc.ServerStreamingServer[PregenerateResult]
+
+// Thumbnails_ServiceDesc is the grpc.ServiceDesc for Thumbnails service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Thumbnails_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gothumb.v1.Thumbnails",
+	HandlerType: (*ThumbnailsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetInfo",
+			Handler:    _Thumbnails_GetInfo_Handler,
+		},
+		{
+			MethodName: "Purge",
+			Handler:    _Thumbnails_Purge_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Resize",
+			Handler:       _Thumbnails_Resize_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Pregenerate",
+			Handler:       _Thumbnails_Pregenerate_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "gothumb.proto",
+}
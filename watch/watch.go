@@ -0,0 +1,213 @@
+// Package watch generates thumbnails for images dropped into a local
+// directory, for hot-folder workflows that have no pipeline to call gothumb
+package watch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/joelchen/gothumb/internal/logging"
+	"github.com/spf13/viper"
+)
+
+// Filtered by log.levels.watch
+var logger = logging.New("watch")
+
+// File is an image that was added or changed in the watched directory
+type File struct {
+	// Location on disk
+	Path string
+	// Source path its thumbnails are cached under: watch.prefix followed by
+	// its path relative to the directory, with forward slashes
+	Source string
+}
+
+// Run watches watch.dir and its subdirectories until the context is done,
+// handing each file to handle once it has gone unchanged for watch.settle,
+// so images still being copied in are not read half written. Up to
+// watch.parallel files are handled at once. With watch.existing set, files
+// already in the directory are handled first.
+func Run(ctx context.Context, handle func(context.Context, File) error) error {
+	dir := viper.GetString("watch.dir")
+
+	if dir == "" {
+		return fmt.Errorf("No watch.dir configured")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return err
+	}
+
+	defer watcher.Close()
+
+	if err = addTree(watcher, dir); err != nil {
+		return err
+	}
+
+	files := make(chan File)
+	var wg sync.WaitGroup
+
+	for i := 0; i < viper.GetInt("watch.parallel"); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for file := range files {
+				if err := handle(ctx, file); err != nil {
+					logger.Errorf("%s: %v", file.Source, err)
+					continue
+				}
+
+				logger.Infof("%s", file.Source)
+			}
+		}()
+	}
+
+	settled := make(chan string)
+	pending := map[string]*time.Timer{}
+	changed := map[string]time.Time{}
+	settle := viper.GetDuration("watch.settle")
+
+	schedule := func(name string) {
+		changed[name] = time.Now()
+
+		if timer, ok := pending[name]; ok {
+			timer.Reset(settle)
+			return
+		}
+
+		pending[name] = time.AfterFunc(settle, func() {
+			select {
+			case settled <- name:
+			case <-ctx.Done():
+			}
+		})
+	}
+
+	defer func() {
+		for _, timer := range pending {
+			timer.Stop()
+		}
+
+		close(files)
+		wg.Wait()
+	}()
+
+	if viper.GetBool("watch.existing") {
+		err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && !hidden(name) {
+				schedule(name)
+			}
+
+			return err
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			logger.Errorf("watch: %v", err)
+		case event := <-watcher.Events:
+			if hidden(event.Name) || !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+
+			info, err := os.Stat(event.Name)
+
+			if err != nil {
+				continue
+			}
+
+			// Directories created or moved in are watched too, along with
+			// anything they already hold
+			if info.IsDir() {
+				if err := addTree(watcher, event.Name); err != nil {
+					logger.Errorf("watch: %v", err)
+				}
+
+				filepath.Walk(event.Name, func(name string, info os.FileInfo, err error) error {
+					if err == nil && !info.IsDir() && !hidden(name) {
+						schedule(name)
+					}
+
+					return nil
+				})
+
+				continue
+			}
+
+			schedule(event.Name)
+		case name := <-settled:
+			// Changed again after the timer fired, which rearmed it
+			if time.Since(changed[name]) < settle {
+				continue
+			}
+
+			delete(pending, name)
+			delete(changed, name)
+			file, err := newFile(dir, name)
+
+			if err != nil {
+				logger.Errorf("watch: %v", err)
+				continue
+			}
+
+			select {
+			case files <- file:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// Watches a directory and every directory below it
+func addTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+
+		if name != root && hidden(name) {
+			return filepath.SkipDir
+		}
+
+		return watcher.Add(name)
+	})
+}
+
+func newFile(dir, name string) (File, error) {
+	rel, err := filepath.Rel(dir, name)
+
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{
+		Path:   name,
+		Source: path.Join(viper.GetString("watch.prefix"), filepath.ToSlash(rel)),
+	}, nil
+}
+
+// Whether a file is hidden, as editors and copy tools write temporary files
+// that way
+func hidden(name string) bool {
+	base := filepath.Base(name)
+	return strings.HasPrefix(base, ".") || strings.HasSuffix(base, "~")
+}